@@ -0,0 +1,50 @@
+//go:build linux
+
+package main
+
+import (
+  "runtime"
+  "syscall"
+  "unsafe"
+)
+
+const affinitySupported = true
+
+type cpuMask [16]uint64 // Room for 1024 CPUs, as glibc's cpu_set_t has
+
+func schedAffinity(trap uintptr, mask *cpuMask) error {
+  _, _, errno := syscall.RawSyscall(trap, 0, unsafe.Sizeof(*mask), uintptr(unsafe.Pointer(mask)))
+  if errno != 0 {
+    return errno
+  }
+  return nil
+}
+
+// Lock the calling goroutine to its thread and bind the thread to the
+// worker'th of the CPUs it may run on, wrapping around if there are fewer.
+// The returned function puts back the thread's CPUs and unlocks it.
+func pinWorker(worker int) (restore func(), err error) {
+  runtime.LockOSThread()
+  var old cpuMask
+  if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &old); err != nil {
+    runtime.UnlockOSThread()
+    return nil, err
+  }
+  var cpus []int
+  for i := 0; i < len(old) * 64; i++ {
+    if old[i / 64] & (1 << (i % 64)) != 0 {
+      cpus = append(cpus, i)
+    }
+  }
+  var mask cpuMask
+  cpu := cpus[worker % len(cpus)]
+  mask[cpu / 64] = 1 << (cpu % 64)
+  if err := schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &mask); err != nil {
+    runtime.UnlockOSThread()
+    return nil, err
+  }
+  return func() {
+    schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &old)
+    runtime.UnlockOSThread()
+  }, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const affinitySupported = false
+
+func pinWorker(worker int) (restore func(), err error) {
+  return nil, errors.New("-affinity is only supported on linux")
+}
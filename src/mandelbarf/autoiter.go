@@ -0,0 +1,63 @@
+package main
+
+import (
+  "context"
+  "fmt"
+)
+
+const autoIterStable = 0.001 // Stop raising the cap once doubling it lets fewer than this fraction of pixels escape
+
+// Raise cfg's iteration cap until more wouldn't change much: starting from
+// cfg.Iterations, double it while more than autoIterStable of the output
+// pixels, sampled at their centers, are at the cap and the last doubling let
+// at least that many escape, up to ceiling. A pixel that escaped escapes
+// after the same count under any bigger cap, so each pass only iterates the
+// ones still at the cap. Returns the cap and the fraction of pixels at it.
+func (cfg RenderConfig) settleIterations(ceiling int) (int, float64, error) {
+  kernel := cfg.outputKernel()
+  if kernel == nil {
+    return 0, 0, fmt.Errorf("%s has no iteration counts to settle", cfg.Fractal)
+  }
+  radius := cfg.escapeRadius()
+  capped := make([]bool, cfg.Cols * cfg.Rows)
+  for i := range capped {
+    capped[i] = true
+  }
+  rowCapped := make([]int, cfg.Rows)
+  // Iterate the pixels still capped up to maxIter, returning how many still are
+  pass := func(maxIter int) int {
+    eachRow(context.Background(), cfg, func(row int) {
+      n := 0
+      for col := 0; col < cfg.Cols; col++ {
+        i := row * cfg.Cols + col
+        if !capped[i] {
+          continue
+        }
+        v, _ := kernel(float64(col), float64(row), maxIter, radius)
+        if capped[i] = v == maxIter; capped[i] {
+          n++
+        }
+      }
+      rowCapped[row] = n
+    })
+    total := 0
+    for _, n := range rowCapped {
+      total += n
+    }
+    return total
+  }
+
+  pixels := float64(len(capped))
+  iter := cfg.Iterations
+  left := pass(iter)
+  for iter < ceiling && float64(left) > autoIterStable * pixels {
+    next := min(2 * iter, ceiling)
+    stillCapped := pass(next)
+    freed := left - stillCapped
+    iter, left = next, stillCapped
+    if float64(freed) < autoIterStable * pixels {
+      break
+    }
+  }
+  return iter, float64(left) / pixels, nil
+}
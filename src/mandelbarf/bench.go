@@ -0,0 +1,137 @@
+package main
+
+import (
+  "crypto/sha256"
+  "flag"
+  "fmt"
+  "image"
+  "image/color"
+  "os"
+  "time"
+)
+
+// The view bench renders: seahorse valley, small enough to run in seconds
+// but deep enough that iterating dominates
+var benchView = RenderConfig{
+  Fractal: "mandelbrot",
+  XMin: -0.7522, XMax: -0.7372, YMin: 0.0955, YMax: 0.1055,
+  Iterations: 1024,
+  EscapeRadius: escapeThresh,
+  Cols: 360, Rows: 240,
+  ScaleX: 2, ScaleY: 2,
+  Filter: "box",
+  AA: "full",
+  AAThreshold: aaThreshold,
+  LanczosA: lanczosA,
+  Palette: palettes["cyan"],
+  PaletteSpace: "srgb",
+  ColorScale: "linear",
+  Coloring: "smooth",
+  ToneMap: "linear",
+  Workers: workerNum,
+}
+
+// Settings bench compares against the view as it is. Those that only change
+// how the work is done should leave the hash alone.
+var benchSettings = []struct {
+  name string
+  apply func(cfg *RenderConfig)
+}{
+  {"1 worker", func(cfg *RenderConfig) {
+    cfg.Workers = 1
+  }},
+  {"1 chunk per worker", func(cfg *RenderConfig) {
+    cfg.Chunks = cfg.Workers
+  }},
+  {"pinned workers", func(cfg *RenderConfig) {
+    cfg.Affinity = true
+  }},
+  {"center-out chunks", func(cfg *RenderConfig) {
+    cfg.ChunkOrder = "center"
+  }},
+  {"double-double", func(cfg *RenderConfig) {
+    cfg.Precision = "dd"
+  }},
+  {"smart AA", func(cfg *RenderConfig) {
+    cfg.AA = "smart"
+  }},
+}
+
+// Render benchView with each of benchSettings, after a warm-up render, and
+// print a table of the best time of each and whether it changed the output
+func benchCmd(args []string) error {
+  fs := flag.NewFlagSet("bench", flag.ExitOnError)
+  verbosity := logFlags(fs)
+  runs := fs.Int("runs", 3, "time each setting this many times, keeping the best")
+  workers := fs.Int("workers", workerNum, "render with this many goroutines")
+  fs.Parse(args)
+  verbosity()
+  if fs.NArg() > 0 {
+    return fmt.Errorf("bench: unexpected arguments %q", fs.Args())
+  }
+  if *runs < 1 {
+    return fmt.Errorf("-runs must be at least 1, got %d", *runs)
+  }
+  base := benchView
+  base.Workers = *workers
+
+  // Best time and a hash of the pixels of rendering cfg
+  time1 := func(cfg RenderConfig) (time.Duration, string, error) {
+    var best time.Duration
+    var sum string
+    for i := 0; i < *runs; i++ {
+      start := time.Now()
+      m, err := Render(cfg)
+      if err != nil {
+        return 0, "", err
+      }
+      if d := time.Since(start); i == 0 || d < best {
+        best = d
+      }
+      sum = pixelHash(m)
+    }
+    return best, sum, nil
+  }
+
+  if _, err := Render(base); err != nil {
+    return err
+  }
+  baseTime, baseHash, err := time1(base)
+  if err != nil {
+    return err
+  }
+  fmt.Printf("%-20s %10s %8s  %s\n", "setting", "best", "speedup", "pixels")
+  fmt.Printf("%-20s %10v %8s  %s\n", "as is", baseTime.Round(time.Millisecond), "1.00x", baseHash)
+  for _, s := range benchSettings {
+    cfg := base
+    s.apply(&cfg)
+    d, sum, err := time1(cfg)
+    if err != nil {
+      return fmt.Errorf("%s: %v", s.name, err)
+    }
+    same := "same"
+    if sum != baseHash {
+      same = sum + " (differs)"
+    }
+    fmt.Printf("%-20s %10v %7.2fx  %s\n", s.name, d.Round(time.Millisecond), baseTime.Seconds() / d.Seconds(), same)
+  }
+  fmt.Fprintf(os.Stderr, "%dx%d at %dx%d samples, %d iterations, %d workers, best of %d\n",
+    base.Cols, base.Rows, base.ScaleX, base.ScaleY, base.Iterations, base.Workers, *runs)
+  return nil
+}
+
+// The first 12 hex digits of a SHA-256 of m's pixels, as 8-bit RGBA rows
+func pixelHash(m image.Image) string {
+  h := sha256.New()
+  b := m.Bounds()
+  row := make([]byte, 0, 4 * b.Dx())
+  for y := b.Min.Y; y < b.Max.Y; y++ {
+    row = row[:0]
+    for x := b.Min.X; x < b.Max.X; x++ {
+      c := color.RGBAModel.Convert(m.At(x, y)).(color.RGBA)
+      row = append(row, c.R, c.G, c.B, c.A)
+    }
+    h.Write(row)
+  }
+  return fmt.Sprintf("%x", h.Sum(nil))[:12]
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+  "context"
+  "log/slog"
+  "math"
+  "time"
+)
+
+// Samples are drawn in batches, each from its own random stream (see
+// sampleRNG), so the result doesn't depend on which worker draws which
+const buddhaBatch = 1 << 14
+
+const samplesPerPixel = 10 // Default -samples, per output pixel
+
+// Count how often the orbits of escaping points pass through each pixel of a
+// cols x rows image. Each worker counts into its own buffer, and the buffers
+// are summed at the end. Stops early, with a partial count, if ctx is done.
+func buddhabrotDensity(ctx context.Context, cfg RenderConfig, cols, rows int) []uint32 {
+  samples := cfg.Samples
+  if samples == 0 {
+    samples = cols * rows * samplesPerPixel
+  }
+  batches := (samples + buddhaBatch - 1) / buddhaBatch
+  queue := make(chan int, batches)
+  for b := 0; b < batches; b++ {
+    queue <- b
+  }
+  close(queue)
+
+  toPixel := cfg.pixelTransform(cols, rows).invert()
+  results := make(chan []uint32, cfg.Workers)
+  for w := 0; w < cfg.Workers; w++ {
+    go func() {
+      defer cfg.pinWorker(w)()
+      density := make([]uint32, cols * rows)
+      orbit := make([]complex128, 0, cfg.Iterations)
+      for b := range queue {
+        if ctx.Err() != nil {
+          break
+        }
+        rng := sampleRNG(cfg.Seed, b)
+        n := min(buddhaBatch, samples - b * buddhaBatch)
+        for s := 0; s < n; s++ {
+          c := complex(rng.Float64() * 4 - 2, rng.Float64() * 4 - 2)
+          z := c
+          orbit = orbit[:0]
+          escaped := false
+          for i := 0; i < cfg.Iterations; i++ {
+            z = z*z + c
+            if real(z) * real(z) + imag(z) * imag(z) > 4 {
+              escaped = true
+              break
+            }
+            orbit = append(orbit, z)
+          }
+          if !escaped {
+            continue
+          }
+          for _, z := range orbit {
+            x, y := toPixel.apply(real(z), imag(z))
+            col, row := int(math.Round(x)), int(math.Round(y))
+            if 0 <= col && col < cols && 0 <= row && row < rows {
+              density[row * cols + col]++
+            }
+          }
+        }
+      }
+      results <- density
+    }()
+  }
+
+  total := <- results
+  for w := 1; w < cfg.Workers; w++ {
+    for i, d := range <- results {
+      total[i] += d
+    }
+  }
+  return total
+}
+
+// Render the Buddhabrot at output resolution; supersampling a density
+// wouldn't add anything that more samples don't
+func renderBuddhabrot(ctx context.Context, cfg RenderConfig) (img, error) {
+  start := time.Now()
+  slog.Info("render start", "fractal", cfg.Fractal, "cols", cfg.Cols, "rows", cfg.Rows,
+    "iterations", cfg.Iterations, "samples", cfg.Samples, "workers", cfg.Workers)
+  density := buddhabrotDensity(ctx, cfg, cfg.Cols, cfg.Rows)
+  if err := ctx.Err(); err != nil {
+    return img{}, err
+  }
+  slog.Info("render done", "elapsed", time.Since(start))
+
+  colors := cfg.Palette.table(smoothSteps, cfg.PaletteSpace)
+  out := mkImg(cfg.Cols, cfg.Rows)
+  for i, v := range toneMap(cfg.ToneMap, density) {
+    out.px[i] = colors[int(math.Max(0, math.Min(1, v)) * (smoothSteps - 1))]
+  }
+  return out, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+  "fmt"
+  "image/color"
+  "math"
+  "strconv"
+  "strings"
+)
+
+const channelStops = 256 // Stops sampled from a -channels mapping
+
+// A palette from expressions for the red, green and blue channels, comma
+// separated, in terms of v, the position along the palette from 0 to 1.
+// Each is numbers and v joined by + - * / and parentheses, and its value,
+// 0 for none of the channel to 1 for all of it, is clamped to that range.
+// The cyan palette is 0,v,v; gray is v,v,v.
+func channelPalette(text string) (Palette, error) {
+  exprs := strings.Split(text, ",")
+  if len(exprs) != 3 {
+    return nil, fmt.Errorf("need 3 channel expressions, got %d in %q", len(exprs), text)
+  }
+  var fs [3]func(v float64) float64
+  for i, e := range exprs {
+    var err error
+    fs[i], err = parseChannel(e)
+    if err != nil {
+      return nil, err
+    }
+  }
+  g := make(Palette, channelStops)
+  for i := range g {
+    v := float64(i) / (channelStops - 1)
+    var c [3]uint8
+    for k, f := range fs {
+      c[k] = uint8(math.Round(math.Max(0, math.Min(1, f(v))) * 255))
+    }
+    g[i] = color.RGBA{c[0], c[1], c[2], 255}
+  }
+  return g, nil
+}
+
+// Parses a channel expression by recursive descent:
+//   expr   = term { ("+" | "-") term }
+//   term   = factor { ("*" | "/") factor }
+//   factor = number | "v" | "(" expr ")" | "-" factor
+type channelParser struct {
+  text string
+  pos int
+}
+
+func parseChannel(text string) (func(v float64) float64, error) {
+  p := &channelParser{text: text}
+  f, err := p.expr()
+  if err == nil && p.peek() != 0 {
+    err = p.errorf("unexpected %q", p.peek())
+  }
+  if err != nil {
+    return nil, err
+  }
+  return f, nil
+}
+
+func (p *channelParser) errorf(format string, args ...any) error {
+  return fmt.Errorf("channel expression %q at %d: %s", p.text, p.pos + 1, fmt.Sprintf(format, args...))
+}
+
+// The next character past spaces, or 0 at the end
+func (p *channelParser) peek() byte {
+  for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+    p.pos++
+  }
+  if p.pos == len(p.text) {
+    return 0
+  }
+  return p.text[p.pos]
+}
+
+func (p *channelParser) expr() (func(v float64) float64, error) {
+  f, err := p.term()
+  if err != nil {
+    return nil, err
+  }
+  for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+    p.pos++
+    g, err := p.term()
+    if err != nil {
+      return nil, err
+    }
+    f = binaryChannel(op, f, g)
+  }
+  return f, nil
+}
+
+func (p *channelParser) term() (func(v float64) float64, error) {
+  f, err := p.factor()
+  if err != nil {
+    return nil, err
+  }
+  for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+    p.pos++
+    g, err := p.factor()
+    if err != nil {
+      return nil, err
+    }
+    f = binaryChannel(op, f, g)
+  }
+  return f, nil
+}
+
+func (p *channelParser) factor() (func(v float64) float64, error) {
+  switch c := p.peek(); {
+  case c == 'v':
+    p.pos++
+    return func(v float64) float64 {
+      return v
+    }, nil
+  case c == '(':
+    p.pos++
+    f, err := p.expr()
+    if err != nil {
+      return nil, err
+    }
+    if p.peek() != ')' {
+      return nil, p.errorf("missing )")
+    }
+    p.pos++
+    return f, nil
+  case c == '-':
+    p.pos++
+    f, err := p.factor()
+    if err != nil {
+      return nil, err
+    }
+    return func(v float64) float64 {
+      return -f(v)
+    }, nil
+  case c == '.' || '0' <= c && c <= '9':
+    start := p.pos
+    for p.pos < len(p.text) && (p.text[p.pos] == '.' || '0' <= p.text[p.pos] && p.text[p.pos] <= '9') {
+      p.pos++
+    }
+    num := p.text[start:p.pos]
+    n, err := strconv.ParseFloat(num, 64)
+    if err != nil {
+      p.pos = start
+      return nil, p.errorf("bad number %q", num)
+    }
+    return func(float64) float64 {
+      return n
+    }, nil
+  case c == 0:
+    return nil, p.errorf("expression ends early")
+  default:
+    return nil, p.errorf("unexpected %q", c)
+  }
+}
+
+// f op g
+func binaryChannel(op byte, f, g func(v float64) float64) func(v float64) float64 {
+  switch op {
+  case '+':
+    return func(v float64) float64 {
+      return f(v) + g(v)
+    }
+  case '-':
+    return func(v float64) float64 {
+      return f(v) - g(v)
+    }
+  case '*':
+    return func(v float64) float64 {
+      return f(v) * g(v)
+    }
+  default:
+    return func(v float64) float64 {
+      return f(v) / g(v)
+    }
+  }
+}
@@ -0,0 +1,775 @@
+package main
+
+import (
+  "bufio"
+  "context"
+  "errors"
+  "flag"
+  "fmt"
+  "image"
+  "image/color"
+  "log/slog"
+  "math"
+  "os"
+  "reflect"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const fullResWarn = 256 << 20 // Warn before writing a -keep-fullres image with more pixels
+const flatVariance = 1e-4 // Warn of renders whose colors vary less than this
+
+// Subcommands, each parsing its own flags from args
+var commands = map[string]func(args []string) error{
+  "render": renderCmd,
+  "serve": serveCmd,
+  "presets": presetsCmd,
+  "dimension": dimensionCmd,
+  "bench": benchCmd,
+  "jobs": jobsCmd,
+  "selftest": selftestCmd,
+  "version": versionCmd,
+}
+
+func main() {
+  setLogLevel(slog.LevelWarn)
+  if err := run(os.Args[1:]); err != nil {
+    slog.Error(err.Error())
+    os.Exit(1)
+  }
+}
+
+// Log to stderr, so as not to mix with any output on stdout
+func setLogLevel(level slog.Level) {
+  opts := slog.HandlerOptions{Level: level}
+  slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &opts)))
+}
+
+// Register -v and -vv on fs. The returned function sets the log level once fs
+// has been parsed.
+func logFlags(fs *flag.FlagSet) func() {
+  v := fs.Bool("v", false, "log progress to stderr")
+  vv := fs.Bool("vv", false, "log progress and per-chunk detail to stderr")
+  return func() {
+    if *vv {
+      setLogLevel(slog.LevelDebug)
+    } else if *v {
+      setLogLevel(slog.LevelInfo)
+    }
+  }
+}
+
+// Dispatch to a subcommand, defaulting to render. -version is the version
+// command, as most tools spell it.
+func run(args []string) error {
+  name := "render"
+  if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+    name, args = "version", args[1:]
+  } else if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+    name, args = args[0], args[1:]
+  }
+  cmd := commands[name]
+  if cmd == nil {
+    return fmt.Errorf("unknown command %q", name)
+  }
+  return cmd(args)
+}
+
+// Register the flags describing a render on fs. The returned function builds
+// the RenderConfig once fs has been parsed.
+func renderFlags(fs *flag.FlagSet) func() (RenderConfig, error) {
+  fractalName := fs.String("fractal", "mandelbrot", "fractal to render: mandelbrot, tricorn, burningship, julia, multibrot, newton, or buddhabrot")
+  degree := fs.Int("degree", 3, "degree n of z^n - 1 for the newton fractal, or z^n + c for the multibrot")
+  julia := fs.String("julia", "-0.8,0.156", "constant `re,im` of the julia set")
+  bounds := fs.String("bounds", "",
+    "view `xmin,xmax,ymin,ymax` in the complex plane (default framing the fractal)")
+  pan := fs.String("pan", "", "shift the view by `dre,dim` in the complex plane, after -bounds, -preset or -continue have placed it")
+  preset := fs.String("preset", "", "frame a named view of the mandelbrot set, with iterations to suit (list them with the presets command)")
+  iterations := fs.Int("iterations", 0, "iteration cap (default chosen from the zoom level or preset)")
+  radius := fs.Float64("escape-radius", escapeThresh, "treat a point as escaping once |z| exceeds this")
+  norm := fs.String("norm", "l2", "measure |z| against the escape radius by l2, the usual, or linf or l1, which square off the bands")
+  precision := fs.String("precision", "auto", "iterate the mandelbrot set in float64, or dd (double-double) to zoom deeper at a few times the cost; "+
+    "auto picks dd where float64 runs out")
+  scaleAll := fs.Float64("scale", scale, "supersample by this much in both dimensions; "+
+    "a fraction, like 2.5, renders that many times the samples rounded up and resamples them with lanczos")
+  scaleX := fs.Int("scale-x", 0, "horizontal supersample factor (default -scale)")
+  scaleY := fs.Int("scale-y", 0, "vertical supersample factor (default -scale)")
+  aa := fs.String("aa", "full", "supersample every pixel (full), or only those detailed by -aa-threshold (smart)")
+  aaThresh := fs.Float64("aa-threshold", aaThreshold, "with -aa smart, supersample pixels whose neighborhood's color variance exceeds this")
+  filter := fs.String("filter", "box", "downsample by box average, or lanczos for sharper output with less aliasing")
+  lanczosLobes := fs.Int("lanczos-a", lanczosA, "lobes of the lanczos filter; more is sharper but rings more")
+  paletteName := fs.String("palette", "cyan", "named palette: cyan, gray, fire, or ocean")
+  interiorPalette := fs.String("interior-palette", "", "color points that never escape from this named palette, by where their orbit ends")
+  interiorColoring := fs.String("interior-coloring", "end", "with -interior-palette, color by where the orbit ends, "+
+    "by depth, how far inside the escape circle of radius 2 it stays, or by distance to the boundary, "+
+    "estimated from the attracting cycle, so only for points with one")
+  channels := fs.String("channels", "", "instead of a palette, give red, green and blue as `r,g,b` expressions of v, "+
+    "the palette position from 0 to 1, with numbers, + - * / and parentheses; the cyan palette is 0,v,v")
+  gradientFile := fs.String("gradient", "", "read palette colors (one rrggbb per line) from this file")
+  cycles := fs.Int("cycles", 1, "run through the palette this many times")
+  reverse := fs.Bool("reverse", false, "run through the palette backwards")
+  paletteSpace := fs.String("palette-space", "srgb", "interpolate palette colors in srgb or oklab")
+  colorScale := fs.String("color-scale", "linear", "map iterations to palette linearly, or by log or sqrt")
+  colorOffset := fs.Float64("color-offset", 0, "shift iteration counts by this many before coloring them, to move where the palette starts")
+  colorPeriod := fs.Float64("color-period", 0, "run through the palette once every this many iterations, wrapping around, "+
+    "rather than once up to the cap (default 0, the cap)")
+  coloring := fs.String("coloring", "iteration", "color by iteration count, or smooth for no banding")
+  inside := fs.String("inside-color", "", "color points that never escape `rrggbb`, or rrggbbaa (default the palette's first color)")
+  markUncertain := fs.String("mark-uncertain", "", "color points that would escape with more iterations `rrggbb`, to show the cap is too low")
+  alpha := fs.Bool("alpha", false, "make points that never escape transparent")
+  wrapX := fs.Bool("wrap-x", false, "render the bounds as one period of a view repeating left to right, so copies of the image "+
+    "tile side by side without a seam, and warn if its edges don't match; for fractals periodic in re")
+  jitter := fs.Bool("jitter", false, "offset each supersample randomly within its cell, trading aliasing for noise (not with -aa smart)")
+  mask := fs.String("mask", "", "spend effort by the luminance of this PNG `file`, stretched over the image: white pixels get every supersample "+
+    "and the full iteration cap, black ones one sample and a quarter of the cap, and grays in between. "+
+    "Buffered renders (lanczos, -keep-fullres, -mmap) keep every sample, so there it only scales the cap. "+
+    "It isn't saved with the image; give it again with -continue")
+  linearLight := fs.Bool("linear-light", false, "average samples in linear light rather than sRGB, so fine bright detail doesn't darken")
+  workers := fs.Int("workers", workerNum, "render with this many goroutines")
+  maxMemory := fs.Int64("max-memory", availableMemory() >> 20,
+    "refuse renders needing more than this many `MiB` for pixels, rather than run out; 0 for no limit, and by default it's the memory free at start")
+  maxProcs := fs.Int("max-procs", 0, "run the render on at most this many cores, leaving the rest free; "+
+    "-workers beyond this share them (default no cap)")
+  affinity := fs.Bool("affinity", false, "pin each worker to a CPU of its own, which may help caches on big or NUMA machines (linux only)")
+  samples := fs.Int("samples", 0, fmt.Sprintf("points to sample for the buddhabrot (default %d per pixel)", samplesPerPixel))
+  toneMap := fs.String("tonemap", "linear", "show buddhabrot densities by linear, log, gamma, or reinhard tone map")
+  seed := fs.Uint64("seed", 0, "seed for random sampling; the same seed gives the same image with any -workers")
+  chunks := fs.Int("chunks", 0, fmt.Sprintf("divide the image into this many chunks of rows "+
+    "(default %d per worker); too few leaves workers idle at the end, too many adds overhead", chunksPerWorker))
+  chunkOrder := fs.String("chunk-order", "top", "render chunks from the top down, or from the center out so the middle is done first; "+
+    "-tui and the server default to center")
+  roi := fs.String("roi", "", "only render the output pixels in `x,y,w,h`, filling the rest with -unrendered")
+  unrendered := fs.String("unrendered", "808080", "color of pixels outside -roi, as `rrggbb` or rrggbbaa")
+  transform := fs.String("affine", "", "transform the view about its center by `a,b,c,d,e,f`, "+
+    "mapping x,y to a*x + b*y + c, d*x + e*y + f")
+  continueFrom := fs.String("continue", "", "start from the settings saved in a PNG `file` rendered before, changing just the ones given as flags")
+  mmapFile := fs.String("mmap", "", "back the supersampled image with this (new) file, for lanczos or -keep-fullres renders larger than RAM")
+
+  return func() (RenderConfig, error) {
+    cfg := RenderConfig{
+      Fractal: *fractalName,
+      Degree: *degree,
+      Iterations: *iterations,
+      EscapeRadius: *radius,
+      Norm: *norm,
+      Precision: *precision,
+      Cols: imgCols,
+      Rows: imgRows,
+      ScaleX: *scaleX,
+      ScaleY: *scaleY,
+      Filter: *filter,
+      AA: *aa,
+      AAThreshold: *aaThresh,
+      LanczosA: *lanczosLobes,
+      Palette: palettes[*paletteName],
+      PaletteSpace: *paletteSpace,
+      ColorScale: *colorScale,
+      ColorOffset: *colorOffset,
+      ColorPeriod: *colorPeriod,
+      Coloring: *coloring,
+      InteriorColoring: *interiorColoring,
+      Alpha: *alpha,
+      LinearLight: *linearLight,
+      Jitter: *jitter,
+      WrapX: *wrapX,
+      MmapFile: *mmapFile,
+      Workers: *workers,
+      MaxProcs: *maxProcs,
+      Affinity: *affinity,
+      MaxMemory: *maxMemory << 20,
+      Chunks: *chunks,
+      ChunkOrder: *chunkOrder,
+      Samples: *samples,
+      ToneMap: *toneMap,
+      Seed: *seed,
+    }
+    f, ok := fractals[cfg.Fractal]
+    if !ok {
+      return cfg, fmt.Errorf("unknown fractal %q", cfg.Fractal)
+    }
+    k, err := parseFloats(*julia, 2)
+    if err != nil {
+      return cfg, fmt.Errorf("-julia: %v", err)
+    }
+    copy(cfg.Julia[:], k)
+    b := f.bounds[:]
+    if *bounds != "" {
+      var err error
+      b, err = parseFloats(*bounds, 4)
+      if err != nil {
+        return cfg, fmt.Errorf("-bounds: %v", err)
+      }
+    }
+    cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax = b[0], b[1], b[2], b[3]
+    if *preset != "" {
+      if *bounds != "" {
+        return cfg, fmt.Errorf("-preset and -bounds both set the view")
+      }
+      if err := cfg.applyPreset(*preset); err != nil {
+        return cfg, err
+      }
+      if cfg.Iterations == 0 {
+        cfg.Iterations = presets[*preset].iterations
+      }
+    }
+    if *roi != "" || *continueFrom != "" {
+      var err error
+      cfg.Unrendered, err = parseHexColor(*unrendered)
+      if err != nil {
+        return cfg, fmt.Errorf("-unrendered: %v", err)
+      }
+    }
+    if *roi != "" {
+      r, err := parseInts(*roi, 4)
+      if err != nil {
+        return cfg, fmt.Errorf("-roi: %v", err)
+      }
+      if r[2] <= 0 || r[3] <= 0 {
+        return cfg, fmt.Errorf("-roi: empty region %q", *roi)
+      }
+      cfg.ROI = image.Rect(r[0], r[1], r[0] + r[2], r[1] + r[3])
+    }
+    if *transform != "" {
+      m, err := parseFloats(*transform, 6)
+      if err != nil {
+        return cfg, fmt.Errorf("-affine: %v", err)
+      }
+      copy(cfg.Affine[:], m)
+    }
+    if cfg.Iterations == 0 {
+      cfg.Iterations = cfg.autoIterations()
+    }
+    if *scaleAll != math.Trunc(*scaleAll) {
+      if cfg.ScaleX != 0 || cfg.ScaleY != 0 {
+        return cfg, fmt.Errorf("-scale %g isn't whole, so it can't be combined with -scale-x or -scale-y", *scaleAll)
+      }
+      cfg.Supersample = *scaleAll
+    }
+    // Whole sample counts near the fractional ones, for what goes by them
+    if cfg.ScaleX == 0 {
+      cfg.ScaleX = int(math.Ceil(*scaleAll))
+    }
+    if cfg.ScaleY == 0 {
+      cfg.ScaleY = int(math.Ceil(*scaleAll))
+    }
+    if *channels != "" {
+      paletteSet := false
+      fs.Visit(func(f *flag.Flag) { paletteSet = paletteSet || f.Name == "palette" || f.Name == "gradient" })
+      if paletteSet {
+        return cfg, fmt.Errorf("-channels and -palette or -gradient both set the palette")
+      }
+      g, err := channelPalette(*channels)
+      if err != nil {
+        return cfg, fmt.Errorf("-channels: %v", err)
+      }
+      cfg.Palette = g
+    } else if *gradientFile != "" {
+      g, err := loadGradient(*gradientFile)
+      if err != nil {
+        return cfg, err
+      }
+      cfg.Palette = g
+    } else if cfg.Palette == nil {
+      return cfg, fmt.Errorf("unknown palette %q", *paletteName)
+    }
+    if *cycles < 1 {
+      return cfg, fmt.Errorf("-cycles must be at least 1, got %d", *cycles)
+    }
+    if *reverse {
+      cfg.Palette = cfg.Palette.Reversed()
+    }
+    cfg.Palette = cfg.Palette.Repeated(*cycles)
+    if *markUncertain != "" {
+      var err error
+      cfg.Uncertain, err = parseHexColor(*markUncertain)
+      if err != nil {
+        return cfg, fmt.Errorf("-mark-uncertain: %v", err)
+      }
+    }
+    if *inside != "" {
+      var err error
+      cfg.Inside, err = parseHexColor(*inside)
+      if err != nil {
+        return cfg, fmt.Errorf("-inside-color: %v", err)
+      }
+    }
+    if *mask != "" {
+      var err error
+      cfg.Mask, err = loadMask(*mask)
+      if err != nil {
+        return cfg, fmt.Errorf("-mask: %v", err)
+      }
+    }
+    if *interiorPalette != "" {
+      cfg.InteriorPalette = palettes[*interiorPalette]
+      if cfg.InteriorPalette == nil {
+        return cfg, fmt.Errorf("unknown palette %q", *interiorPalette)
+      }
+    }
+    if *continueFrom != "" {
+      base, err := readPNGConfig(*continueFrom)
+      if err != nil {
+        return cfg, fmt.Errorf("-continue: %v", err)
+      }
+      given := map[string]bool{}
+      fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+      cfg = mergeFlags(base, cfg, given)
+      // The saved count was picked for the saved view, so pick again for a
+      // new one, unless it's given
+      moved := [4]float64{cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax} != [4]float64{base.XMin, base.XMax, base.YMin, base.YMax}
+      if moved && !given["iterations"] && !given["preset"] {
+        cfg.Iterations = cfg.autoIterations()
+      }
+    }
+    // Last, to shift the view however it was arrived at
+    if *pan != "" {
+      d, err := parseFloats(*pan, 2)
+      if err != nil {
+        return cfg, fmt.Errorf("-pan: %v", err)
+      }
+      RegionOf(cfg).PanBy(d[0], d[1]).Apply(&cfg)
+    }
+    return cfg, nil
+  }
+}
+
+// The RenderConfig fields each render flag sets
+var flagFields = map[string][]string{
+  "fractal": {"Fractal"},
+  "degree": {"Degree"},
+  "julia": {"Julia"},
+  "bounds": {"XMin", "XMax", "YMin", "YMax"},
+  "preset": {"XMin", "XMax", "YMin", "YMax", "Iterations"},
+  "iterations": {"Iterations"},
+  "escape-radius": {"EscapeRadius"},
+  "norm": {"Norm"},
+  "precision": {"Precision"},
+  "scale": {"ScaleX", "ScaleY", "Supersample"},
+  "scale-x": {"ScaleX"},
+  "scale-y": {"ScaleY"},
+  "aa": {"AA"},
+  "aa-threshold": {"AAThreshold"},
+  "filter": {"Filter"},
+  "lanczos-a": {"LanczosA"},
+  "palette": {"Palette"},
+  "gradient": {"Palette"},
+  "channels": {"Palette"},
+  "cycles": {"Palette"},
+  "reverse": {"Palette"},
+  "interior-palette": {"InteriorPalette"},
+  "interior-coloring": {"InteriorColoring"},
+  "palette-space": {"PaletteSpace"},
+  "color-scale": {"ColorScale"},
+  "color-offset": {"ColorOffset"},
+  "color-period": {"ColorPeriod"},
+  "coloring": {"Coloring"},
+  "mark-uncertain": {"Uncertain"},
+  "inside-color": {"Inside"},
+  "alpha": {"Alpha"},
+  "linear-light": {"LinearLight"},
+  "jitter": {"Jitter"},
+  "wrap-x": {"WrapX"},
+  "mask": {"Mask"},
+  "samples": {"Samples"},
+  "tonemap": {"ToneMap"},
+  "seed": {"Seed"},
+  "roi": {"ROI"},
+  "unrendered": {"Unrendered"},
+  "affine": {"Affine"},
+}
+
+// Settings of the machine rendering rather than of the image, which always
+// come from this run's flags. They're left out of the embedded config, so
+// the same image comes out as the same bytes however it was rendered.
+var localFields = []string{"Workers", "MaxProcs", "Affinity", "MaxMemory", "Chunks", "ChunkOrder", "MmapFile"}
+
+// base with the fields set by the given flags taken from flags, the config
+// they built. The palette flags build a whole palette, so any one of them
+// replaces base's palette, and a preset brings its iterations.
+func mergeFlags(base, flags RenderConfig, given map[string]bool) RenderConfig {
+  dst, src := reflect.ValueOf(&base).Elem(), reflect.ValueOf(flags)
+  take := func(field string) {
+    dst.FieldByName(field).Set(src.FieldByName(field))
+  }
+  for name, fields := range flagFields {
+    if given[name] {
+      for _, f := range fields {
+        take(f)
+      }
+    }
+  }
+  for _, f := range localFields {
+    take(f)
+  }
+  return base
+}
+
+// Bytes of memory available to start new work without swapping, as the
+// kernel estimates it, or 0 if it can't be found out
+func availableMemory() int64 {
+  meminfo, err := os.ReadFile("/proc/meminfo")
+  if err != nil {
+    return 0
+  }
+  for _, line := range strings.Split(string(meminfo), "\n") {
+    var kb int64
+    if _, err := fmt.Sscanf(line, "MemAvailable: %d kB", &kb); err == nil {
+      return kb << 10
+    }
+  }
+  return 0
+}
+
+// Whether the flag called name was given on the command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+  set := false
+  fs.Visit(func(f *flag.Flag) {
+    if f.Name == name {
+      set = true
+    }
+  })
+  return set
+}
+
+// Parse n comma-separated numbers
+func parseFloats(s string, n int) ([]float64, error) {
+  fields := strings.Split(s, ",")
+  if len(fields) != n {
+    return nil, fmt.Errorf("want %d comma-separated numbers, got %q", n, s)
+  }
+  v := make([]float64, n)
+  for i, f := range fields {
+    var err error
+    v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64)
+    if err != nil {
+      return nil, err
+    }
+  }
+  return v, nil
+}
+
+// Parse n comma-separated integers
+func parseInts(s string, n int) ([]int, error) {
+  fields := strings.Split(s, ",")
+  if len(fields) != n {
+    return nil, fmt.Errorf("want %d comma-separated integers, got %q", n, s)
+  }
+  v := make([]int, n)
+  for i, f := range fields {
+    var err error
+    v[i], err = strconv.Atoi(strings.TrimSpace(f))
+    if err != nil {
+      return nil, err
+    }
+  }
+  return v, nil
+}
+
+// Render an image and write it to the output file
+func renderCmd(args []string) error {
+  fs := flag.NewFlagSet("render", flag.ExitOnError)
+  config := renderFlags(fs)
+  verbosity := logFlags(fs)
+  emitGLSL := fs.Bool("emit-glsl", false, "instead of rendering, print a GLSL shader drawing the view")
+  tui := fs.Bool("tui", false, "explore interactively in the terminal instead of writing a file")
+  compare := fs.Bool("compare", false, "instead of the render, write the view at several AA settings side by side to "+
+    outFileName+", each labeled with its render time")
+  keepFullRes := fs.String("keep-fullres", "", "also write the supersampled image, before downsampling, to this `file`")
+  interlace := fs.Bool("interlace", false, "write an Adam7-interlaced PNG, which browsers show progressively as it loads")
+  compression := fs.String("compression", "default", "PNG compression: default, fast, best, or none")
+  iterMap := fs.String("iter-map", "", "also write each pixel's iteration count to this `file`, as a 16-bit PGM")
+  floatOut := fs.String("float-out", "", "also write each pixel's smoothed escape value to this `file`, as raw little-endian float32 "+
+    "after uint32 columns and rows")
+  svg := fs.String("svg", "", "also trace the outline of the set to this `file` as an SVG path, for vector work")
+  svgThreshold := fs.Int("svg-threshold", 0, "with -svg, outline the points taking at least this many iterations to escape (default the cap: the set itself)")
+  svgTolerance := fs.Float64("svg-tolerance", 0.5, "with -svg, simplify the outline, letting it stray up to this many pixels")
+  thumbnail := fs.String("thumbnail", "", "also write a copy of the image resized to `w,h`, no bigger than it, to "+thumbFileName)
+  quiet := fs.Bool("quiet", false, "don't print a summary of the render to stderr")
+  autoIter := fs.Bool("auto-iterations", false, "before rendering, double the iteration cap while that lets many more pixels escape, "+
+    "and report the cap chosen")
+  maxIter := fs.Int("max-iterations", 1 << 20, "with -auto-iterations, don't raise the cap past this")
+  colorTag := fs.String("color-tag", "srgb", "tag the PNG as srgb, as full (sRGB with gAMA and cHRM fallbacks), or none")
+  tiles := fs.String("tiles", "", "instead of "+outFileName+", render the image as `nx,ny` tiles, each on its own, to "+
+    fmt.Sprintf(tileFileName, 0, 0)+" and so on by column and row, for stitching")
+  overlap := fs.Int("tile-overlap", tileOverlap, "with -tiles, render each tile this many pixels past its edges and drop them, "+
+    "so antialiasing matches across the seams")
+  fs.Parse(args)
+  verbosity()
+  cfg, err := config()
+  if err != nil {
+    return err
+  }
+  pngOpts, err := parsePNGOptions(*colorTag, *interlace, *compression)
+  if err != nil {
+    return err
+  }
+  if *autoIter {
+    if err := cfg.validate(); err != nil {
+      return err
+    }
+    if *maxIter < cfg.Iterations {
+      return fmt.Errorf("-max-iterations %d is below the starting cap of %d", *maxIter, cfg.Iterations)
+    }
+    var capped float64
+    cfg.Iterations, capped, err = cfg.settleIterations(*maxIter)
+    if err != nil {
+      return fmt.Errorf("-auto-iterations: %v", err)
+    }
+    if !*quiet {
+      fmt.Fprintf(os.Stderr, "-auto-iterations: %d iterations, with %.3g%% of pixels never escaping\n", cfg.Iterations, capped * 100)
+    }
+  }
+  if *emitGLSL {
+    if err := cfg.validate(); err != nil {
+      return err
+    }
+    return writeGLSL(os.Stdout, cfg)
+  }
+  if *tui {
+    if err := cfg.validate(); err != nil {
+      return err
+    }
+    if !flagSet(fs, "chunk-order") {
+      cfg.ChunkOrder = "center"
+    }
+    return runTUI(cfg, !flagSet(fs, "iterations"))
+  }
+  if *compare {
+    if cfg.Fractal == "buddhabrot" {
+      return errors.New("-compare: the buddhabrot isn't supersampled")
+    }
+    m, err := compareAA(cfg)
+    if err != nil {
+      return err
+    }
+    _, err = writePNG(outFileName, m, pngOpts)
+    return err
+  }
+  if *tiles != "" {
+    n, err := parseInts(*tiles, 2)
+    if err != nil {
+      return fmt.Errorf("-tiles: %v", err)
+    }
+    return renderTiles(cfg, n[0], n[1], *overlap, pngOpts, *quiet)
+  }
+
+  if *iterMap != "" && cfg.kernel() == nil {
+    return fmt.Errorf("-iter-map: %s has no iteration counts to map", cfg.Fractal)
+  }
+  if *floatOut != "" && cfg.kernel() == nil {
+    return fmt.Errorf("-float-out: %s has no escape values to map", cfg.Fractal)
+  }
+  if *svg != "" && cfg.kernel() == nil {
+    return fmt.Errorf("-svg: %s has no iteration counts to trace", cfg.Fractal)
+  }
+  if *svgThreshold == 0 {
+    *svgThreshold = cfg.Iterations
+  }
+  var thumbSize []int
+  if *thumbnail != "" {
+    thumbSize, err = parseInts(*thumbnail, 2)
+    if err != nil {
+      return fmt.Errorf("-thumbnail: %v", err)
+    }
+    if thumbSize[0] < 1 || thumbSize[1] < 1 || thumbSize[0] > cfg.Cols || thumbSize[1] > cfg.Rows {
+      return fmt.Errorf("-thumbnail: %dx%d isn't from 1x1 to the image's %dx%d",
+        thumbSize[0], thumbSize[1], cfg.Cols, cfg.Rows)
+    }
+  }
+  if *keepFullRes != "" {
+    if cfg.Fractal == "buddhabrot" {
+      return errors.New("-keep-fullres: the buddhabrot isn't supersampled")
+    }
+    cols, rows := cfg.renderSize()
+    if pixels := cols * rows; pixels > fullResWarn {
+      slog.Warn("-keep-fullres image is huge; encoding it takes a while and a lot of disk",
+        "cols", cols, "rows", rows, "megapixels", pixels >> 20)
+    }
+    cfg.FullRes = func(m image.Image) error {
+      _, err := writePNG(*keepFullRes, m, pngOpts)
+      return err
+    }
+  }
+
+  if *iterMap != "" || *floatOut != "" || *svg != "" {
+    // Kept by the workers as they render, rather than iterated again
+    cfg.Escapes = NewEscapeMap(cfg.Cols, cfg.Rows)
+  }
+
+  pngOpts.config = &cfg
+  renderStart := time.Now()
+  var size int64
+  var stats colorStats
+  if pngOpts.interlace || len(cfg.Overlays) > 0 || !cfg.opaque() || thumbSize != nil || cfg.WrapX {
+    // These need the whole image before they can encode any of it, or to
+    // check it
+    r := NewRenderer(cfg)
+    logged := 0
+    for p := range r.Start(context.Background()) {
+      // Log each tenth of the way
+      if p.Rows * 10 / p.Total > logged {
+        logged = p.Rows * 10 / p.Total
+        slog.Info("render progress", "rows", p.Rows, "of", p.Total, "elapsed", p.Elapsed.Round(time.Millisecond))
+      }
+    }
+    renderSmall, err := r.Result()
+    if err != nil {
+      return err
+    }
+    size, err = writePNG(outFileName, renderSmall, pngOpts)
+    if err != nil {
+      return err
+    }
+    if cfg.WrapX {
+      if seam, typical := wrapSeam(renderSmall); seam > wrapTolerance * typical {
+        slog.Warn("-wrap-x: the left and right edges don't match, so the image won't tile seamlessly; "+
+          "the view may not span one period", "seam", seam, "typical", typical)
+      }
+    }
+    if thumbSize != nil {
+      thumb, err := Resize(renderSmall, thumbSize[0], thumbSize[1])
+      if err != nil {
+        return err
+      }
+      if _, err := writePNG(thumbFileName, thumb, pngOpts); err != nil {
+        return err
+      }
+    }
+    b := renderSmall.Bounds()
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+      for x := b.Min.X; x < b.Max.X; x++ {
+        stats.add(color.RGBAModel.Convert(renderSmall.At(x, y)).(color.RGBA))
+      }
+    }
+  } else {
+    size, err = streamPNG(outFileName, cfg, pngOpts, &stats)
+    if err != nil {
+      return err
+    }
+  }
+  if !*quiet {
+    printSummary(cfg, time.Since(renderStart), size)
+    if stats.variance() < flatVariance {
+      slog.Warn("render is nearly one flat color; the view may be all inside the set or all far outside it. "+
+        "Check -bounds, or try more -iterations", "variance", stats.variance())
+    }
+  }
+  if *iterMap != "" {
+    if err := saveIterMap(*iterMap, cfg.Escapes, cfg.Iterations); err != nil {
+      return err
+    }
+  }
+  if *floatOut != "" {
+    if err := saveFloatMap(*floatOut, cfg.Escapes); err != nil {
+      return err
+    }
+  }
+  if *svg != "" {
+    return saveSVG(*svg, cfg.Escapes, *svgThreshold, *svgTolerance)
+  }
+  return nil
+}
+
+// Write m to a PNG file encoded as opts says, returning the file's size.
+// Errors are all *EncodeError.
+func writePNG(fileName string, m image.Image, opts pngOptions) (size int64, err error) {
+  defer func() {
+    if err != nil {
+      err = &EncodeError{fileName, err}
+    }
+  }()
+  file, err := os.Create(fileName)
+  if err != nil {
+    return 0, err
+  }
+  defer file.Close()
+
+  start := time.Now()
+  slog.Info("encode start", "format", "png", "file", fileName)
+  w := bufio.NewWriter(file)
+  if err := encodePNG(w, m, opts); err != nil {
+    return 0, err
+  }
+  if err := w.Flush(); err != nil {
+    return 0, err
+  }
+  slog.Info("encode done", "elapsed", time.Since(start))
+  info, err := file.Stat()
+  if err != nil {
+    return 0, err
+  }
+  return info.Size(), file.Close()
+}
+
+// Render cfg to a PNG file like writePNG, but encode rows in order as they
+// finish, alongside the workers rendering the rest, rather than once the
+// whole image is done, adding its pixels to stats. The image must be opaque
+// and not interlaced. Errors are all *RenderError or *EncodeError.
+func streamPNG(fileName string, cfg RenderConfig, opts pngOptions, stats *colorStats) (size int64, err error) {
+  file, err := os.Create(fileName)
+  if err != nil {
+    return 0, &EncodeError{fileName, err}
+  }
+  defer file.Close()
+  w := bufio.NewWriter(file)
+  enc, err := newPNGRowWriter(w, cfg.Cols, cfg.Rows, opts)
+  if err != nil {
+    return 0, &EncodeError{fileName, err}
+  }
+
+  // The encoder takes rows off a channel roomy enough that the render never
+  // waits for it, and stops the render if it fails
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  rows := make(chan []color.RGBA, cfg.Rows)
+  encoded := make(chan error, 1)
+  go func() {
+    var err error
+    for pixels := range rows {
+      for _, p := range pixels {
+        stats.add(p)
+      }
+      if err == nil {
+        if err = enc.row(pixels); err != nil {
+          cancel()
+        }
+      }
+    }
+    if err == nil {
+      err = enc.close()
+    }
+    encoded <- err
+  }()
+  slog.Info("encode start", "format", "png", "file", fileName, "streaming", true)
+  renderErr := renderStream(ctx, cfg, func(row int, pixels []color.RGBA) {
+    rows <- append([]color.RGBA(nil), pixels...)
+  })
+  close(rows)
+  if err := <- encoded; err != nil {
+    return 0, &EncodeError{fileName, err}
+  }
+  if renderErr != nil {
+    return 0, renderErr
+  }
+  if err := w.Flush(); err != nil {
+    return 0, &EncodeError{fileName, err}
+  }
+  slog.Info("encode done")
+  info, err := file.Stat()
+  if err != nil {
+    return 0, &EncodeError{fileName, err}
+  }
+  if err := file.Close(); err != nil {
+    return 0, &EncodeError{fileName, err}
+  }
+  return info.Size(), nil
+}
+
+// Print how long a render took, how much work it did, and how big the file is
+func printSummary(cfg RenderConfig, elapsed time.Duration, size int64) {
+  points, workers := cfg.workload()
+  fmt.Fprintf(os.Stderr, "%s: %v, %d points (%.3g/s), %d workers, %d bytes\n",
+    outFileName, elapsed.Round(time.Millisecond), points,
+    float64(points) / elapsed.Seconds(), workers, size)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+  "image/color"
+  "math"
+  "math/cmplx"
+)
+
+// A Colorer picks the color of a point from the result of iterating it:
+// whether it escaped, after how many iterations (possibly fractional), and
+// the final value of z.
+type Colorer interface {
+  Color(escaped bool, iter float64, z complex128) color.RGBA
+}
+
+var colorers = map[string]func(cfg RenderConfig) Colorer{}
+
+// Make a coloring available to -coloring as name. newColorer is called once
+// per render with its configuration.
+func RegisterColorer(name string, newColorer func(cfg RenderConfig) Colorer) {
+  colorers[name] = newColorer
+}
+
+// The Colorer for cfg: its -coloring for escaped points, and for points
+// that never escape, its inside color or interior palette if it has one, or
+// its uncertain color if they're sure to escape later
+func newColorer(cfg RenderConfig) Colorer {
+  c := colorers[cfg.Coloring](cfg)
+  if cfg.Inside.A != 0 {
+    c = insideColorer{c, cfg.Inside}
+  }
+  if cfg.InteriorPalette != nil {
+    c = interiorColorer{c, cfg.InteriorPalette.table(256, cfg.PaletteSpace)}
+  }
+  if cfg.Uncertain.A != 0 {
+    c = uncertainColorer{c, cfg.Uncertain, cfg.certainEscape()}
+  }
+  return c
+}
+
+func init() {
+  RegisterColorer("iteration", newIterationColorer)
+  RegisterColorer("smooth", newSmoothColorer)
+}
+
+// Color by iteration count, looked up in a table with a color per count up
+// to the cap, and at least 256. Caps too big for that share each color
+// among neighboring counts. With a color offset or period, counts are
+// mapped by position into a finely sampled palette instead.
+type iterationColorer struct {
+  colors []color.RGBA
+  last int // Largest count an escaped point can have, or n - 1 if smaller
+  position func(iter float64) float64 // If set, maps counts into colors
+}
+
+const maxIterationColors = 1 << 16
+
+func newIterationColorer(cfg RenderConfig) Colorer {
+  n := min(max(cfg.Iterations, 256), maxIterationColors)
+  last := max(n - 1, cfg.Iterations - 1)
+  if cfg.ColorOffset != 0 || cfg.ColorPeriod > 0 {
+    return iterationColorer{cfg.Palette.table(smoothSteps, cfg.PaletteSpace), last, cfg.palettePosition(float64(last))}
+  }
+  return iterationColorer{colorTable(cfg, n), last, nil}
+}
+
+func (c iterationColorer) Color(escaped bool, iter float64, z complex128) color.RGBA {
+  if !escaped {
+    return c.colors[0]
+  }
+  if c.position != nil {
+    t := c.position(float64(int(iter)))
+    return c.colors[int(math.Max(0, math.Min(1, t)) * float64(len(c.colors) - 1))]
+  }
+  i := min(max(int(iter), 0), c.last)
+  return c.colors[i * (len(c.colors) - 1) / c.last]
+}
+
+// Color by a fractional iteration count, which removes the banding
+type smoothColorer struct {
+  colors []color.RGBA // The palette, finely sampled
+  position func(iter float64) float64
+  logRadius float64 // Log of the escape radius the kernel used
+  norm func(z complex128) float64 // The norm it measured z by
+}
+
+const smoothSteps = 4096
+
+func newSmoothColorer(cfg RenderConfig) Colorer {
+  return smoothColorer{
+    cfg.Palette.table(smoothSteps, cfg.PaletteSpace),
+    cfg.palettePosition(float64(cfg.Iterations)),
+    math.Log(cfg.escapeRadius()),
+    cfg.norm(),
+  }
+}
+
+func (s smoothColorer) Color(escaped bool, iter float64, z complex128) color.RGBA {
+  if !escaped {
+    return s.colors[0]
+  }
+  iter = smoothIter(iter, s.norm(z), s.logRadius)
+  t := s.position(iter)
+  return s.colors[int(math.Max(0, math.Min(1, t)) * (smoothSteps - 1))]
+}
+
+// A fractional iteration count for a point that escaped after iter
+// iterations, ending at a z of norm r, with logRadius the log of the escape
+// radius. Once past the radius, log r roughly doubles each iteration, so
+// this adds how far into the escaping iteration the radius was crossed. It
+// stays within its count's band, iter to iter + 1, only if the radius and
+// norm are the ones the kernel escaped by; another radius shifts every
+// value by the same amount.
+func smoothIter(iter, r, logRadius float64) float64 {
+  return iter + 1 - math.Log2(math.Log(r) / logRadius)
+}
+
+// Color escaped points with exterior, and the rest by where their orbit
+// ended up: |z| is at most 2 inside the set, and varies smoothly across
+// each component. With depth interior coloring, the kernel ends the orbit
+// at its farthest point, and with distance coloring, pointColorer puts z
+// out by nearness to the boundary, so the palette runs from the deepest
+// points to those nearest the boundary.
+type interiorColorer struct {
+  exterior Colorer
+  colors []color.RGBA
+}
+
+func (c interiorColorer) Color(escaped bool, iter float64, z complex128) color.RGBA {
+  if escaped {
+    return c.exterior.Color(escaped, iter, z)
+  }
+  t := math.Min(1, cmplx.Abs(z) / 2)
+  return c.colors[int(t * float64(len(c.colors) - 1))]
+}
+
+// Color points that never escape inside, whatever the exterior coloring
+// would have given them
+type insideColorer struct {
+  exterior Colorer
+  inside color.RGBA
+}
+
+func (c insideColorer) Color(escaped bool, iter float64, z complex128) color.RGBA {
+  if !escaped {
+    return c.inside
+  }
+  return c.exterior.Color(escaped, iter, z)
+}
+
+// Once |z| passes bound, cfg's certainEscape, the orbit is certain to
+// escape, so a point that ran out of iterations beyond that was only cut off
+// by the iteration cap
+func uncertain(escaped bool, z complex128, bound float64) bool {
+  return !escaped && cmplx.Abs(z) > bound
+}
+
+// How far out |z| must get for the orbit to be certain to escape, whatever
+// the escape radius: past 2 and |c|, |z^2 + c| > |z|. The mandelbrot set and
+// its relatives start their orbits at c, so are sure to escape past 2 alone,
+// but a julia set's c is its constant, which may be bigger.
+func (cfg RenderConfig) certainEscape() float64 {
+  if cfg.Fractal == "julia" {
+    return math.Max(2, math.Hypot(cfg.Julia[0], cfg.Julia[1]))
+  }
+  return 2
+}
+
+// Color points cut off by the iteration cap with mark, to show that the cap
+// is too low
+type uncertainColorer struct {
+  inner Colorer
+  mark color.RGBA
+  bound float64 // Of certain escape
+}
+
+func (c uncertainColorer) Color(escaped bool, iter float64, z complex128) color.RGBA {
+  if uncertain(escaped, z, c.bound) {
+    return c.mark
+  }
+  return c.inner.Color(escaped, iter, z)
+}
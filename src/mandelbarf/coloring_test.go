@@ -0,0 +1,120 @@
+package main
+
+import (
+  "image/color"
+  "math"
+  "math/cmplx"
+  "testing"
+)
+
+// Smoothed escape values along the real axis from 0.26, just outside the
+// cusp, to 1, escaping at radius and smoothing by smoothRadius: the largest
+// jump between neighboring points, and the farthest any value strays out of
+// [iter, iter + 1) for its count. The points are much closer than pixels of
+// any view of the set, and cross dozens of iteration bands.
+func smoothAlongAxis(radius, smoothRadius float64) (jump, stray float64) {
+  const steps = 100000
+  prev := 0.0
+  for i := 0; i <= steps; i++ {
+    c := complex(lerp(0.26, 1, float64(i) / steps), 0)
+    v, z := mandelbrot(c, 1000, radius)
+    smooth := smoothIter(float64(v), cmplx.Abs(z), math.Log(smoothRadius))
+    if i > 0 {
+      jump = math.Max(jump, math.Abs(smooth - prev))
+    }
+    stray = math.Max(stray, math.Max(float64(v) - smooth, smooth - float64(v + 1)))
+    prev = smooth
+  }
+  return jump, stray
+}
+
+// Smoothed by the radius the kernel escaped at, the value steps smoothly
+// between neighboring points across band boundaries, and stays in its
+// count's band, at any radius. Smoothed by another radius, it's shifted out
+// of the band.
+func TestSmoothContinuousAtRadius(t *testing.T) {
+  for _, radius := range []float64{4, escapeThresh, 1e6} {
+    jump, stray := smoothAlongAxis(radius, radius)
+    if jump > 0.05 {
+      t.Errorf("radius %g: smoothed value jumps by %.3f between neighbors", radius, jump)
+    }
+    if stray > 0.05 {
+      t.Errorf("radius %g: smoothed value strays %.3f out of its band", radius, stray)
+    }
+  }
+  if _, stray := smoothAlongAxis(escapeThresh, 2); stray < 1 {
+    t.Errorf("smoothing radius %g escapes by 2 strays only %.3f out of band", escapeThresh, stray)
+  }
+}
+
+// Points that never escape get exactly the inside color, or without one the
+// palette's first, at caps below, at, and past 256
+func TestInteriorColor(t *testing.T) {
+  teal := color.RGBA{0x12, 0x80, 0x80, 255}
+  for _, args := range [][]string{
+    {"-inside-color", "128080"},
+    {"-inside-color", "128080", "-coloring", "smooth"},
+    {"-inside-color", "128080", "-cycles", "3", "-color-period", "40"},
+    {"-inside-color", "128080", "-linear-light"},
+    {"-palette", "fire"},
+    {"-palette", "fire", "-coloring", "smooth"},
+  } {
+    for _, iterations := range []string{"100", "256", "1000"} {
+      cfg := testConfig(t, append(args, "-iterations", iterations, "-scale", "1")...)
+      cfg.Escapes = NewEscapeMap(cfg.Cols, cfg.Rows)
+      want := teal
+      if cfg.Inside.A == 0 {
+        want = cfg.Palette[0]
+      }
+      inside := 0
+      for i, c := range testRender(t, cfg) {
+        if int(cfg.Escapes.Iter[i]) == cfg.Iterations {
+          inside++
+          if c != want {
+            t.Fatalf("%v at %s iterations: interior pixel %d is %v, want %v", args, iterations, i, c, want)
+          }
+        }
+      }
+      if inside == 0 {
+        t.Fatalf("%v at %s iterations: no interior pixels", args, iterations)
+      }
+    }
+  }
+}
+
+// Raising the cap from 256 to 2000 leaves the smoothed values of points that
+// had escaped, and with a color period their colors, exactly where they were
+func TestSmoothStableAcrossCaps(t *testing.T) {
+  for _, c := range []complex128{2, complex(0.26, 0), complex(-0.75, 0.1), complex(-1.8, 0.01), complex(0.4, 0.5), complex(-0.7454, 0.1130)} {
+    iter, low, escaped := EscapeTime(c, 256, escapeThresh)
+    if !escaped {
+      t.Fatalf("%v doesn't escape within 256 iterations", c)
+    }
+    if _, high, _ := EscapeTime(c, 2000, escapeThresh); high != low {
+      t.Errorf("%v, escaping after %d: smoothed %g at cap 256 but %g at 2000", c, iter, low, high)
+    }
+  }
+
+  render := func(iterations string) (*EscapeMap, []color.RGBA) {
+    cfg := testConfig(t, "-coloring", "smooth", "-color-period", "64", "-scale", "1",
+      "-bounds", "-0.76,-0.73,0.09,0.11", "-iterations", iterations)
+    cfg.Escapes = NewEscapeMap(cfg.Cols, cfg.Rows)
+    return cfg.Escapes, testRender(t, cfg)
+  }
+  lowMap, lowPx := render("256")
+  highMap, highPx := render("2000")
+  escaped := 0
+  for i, v := range lowMap.Iter {
+    if v == 256 {
+      continue
+    }
+    escaped++
+    if lowMap.Smooth[i] != highMap.Smooth[i] || lowPx[i] != highPx[i] {
+      t.Errorf("pixel %d: smoothed %g colored %v at cap 256, but %g colored %v at 2000", i,
+        lowMap.Smooth[i], lowPx[i], highMap.Smooth[i], highPx[i])
+    }
+  }
+  if escaped == 0 {
+    t.Error("no pixels escaped at cap 256")
+  }
+}
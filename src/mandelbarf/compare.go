@@ -0,0 +1,140 @@
+package main
+
+import (
+  "fmt"
+  "image"
+  "image/color"
+  "image/draw"
+  "strings"
+  "time"
+)
+
+const comparePanelCols = 640 // Widest a panel of -compare gets
+const labelScale = 2 // Screen pixels per font pixel in labels
+
+// AA settings -compare renders side by side, as changes to the view's
+// config
+var compareSettings = []struct {
+  name string
+  apply func(cfg *RenderConfig)
+}{
+  {"1x", func(cfg *RenderConfig) {
+    cfg.ScaleX, cfg.ScaleY = 1, 1
+  }},
+  {"box 2x", func(cfg *RenderConfig) {
+    cfg.ScaleX, cfg.ScaleY = 2, 2
+  }},
+  {"box 4x", func(cfg *RenderConfig) {
+    cfg.ScaleX, cfg.ScaleY = 4, 4
+  }},
+  {"lanczos 4x", func(cfg *RenderConfig) {
+    cfg.ScaleX, cfg.ScaleY = 4, 4
+    cfg.Filter = "lanczos"
+  }},
+  {"jitter 4x", func(cfg *RenderConfig) {
+    cfg.ScaleX, cfg.ScaleY = 4, 4
+    cfg.Jitter = true
+  }},
+}
+
+// Render cfg's view with each of compareSettings, in panels of the same
+// aspect ratio side by side, each labeled below with its setting and how
+// long it took
+func compareAA(cfg RenderConfig) (image.Image, error) {
+  cfg.AA, cfg.Filter, cfg.Jitter, cfg.Supersample = "full", "box", false, 0
+  cfg.ROI = image.Rectangle{}
+  cols := min(cfg.Cols, comparePanelCols)
+  rows := max(1, cols * cfg.Rows / cfg.Cols)
+  cfg.Cols, cfg.Rows = cols, rows
+  labelRows := (glyphRows + 2) * labelScale
+  out := image.NewRGBA(image.Rect(0, 0, cols * len(compareSettings), rows + labelRows))
+  draw.Draw(out, out.Bounds(), image.Black, image.Point{}, draw.Src)
+  for i, s := range compareSettings {
+    panel := cfg
+    s.apply(&panel)
+    start := time.Now()
+    m, err := Render(panel)
+    if err != nil {
+      return nil, err
+    }
+    elapsed := time.Since(start)
+    at := image.Pt(i * cols, 0)
+    draw.Draw(out, image.Rectangle{at, at.Add(image.Pt(cols, rows))}, m, image.Point{}, draw.Src)
+    label := fmt.Sprintf("%s %.2fs", s.name, elapsed.Seconds())
+    drawText(out, i * cols + labelScale, rows + labelScale, label, color.White)
+  }
+  return out, nil
+}
+
+const glyphRows = 5 // Every glyph is 3 pixels wide and this many high
+
+// A tiny capital font, enough for labels. Each glyph is its rows from the
+// top, # for a lit pixel.
+var glyphs = map[rune][glyphRows]string{
+  'A': {".#.", "#.#", "###", "#.#", "#.#"},
+  'B': {"##.", "#.#", "##.", "#.#", "##."},
+  'C': {".##", "#..", "#..", "#..", ".##"},
+  'D': {"##.", "#.#", "#.#", "#.#", "##."},
+  'E': {"###", "#..", "##.", "#..", "###"},
+  'F': {"###", "#..", "##.", "#..", "#.."},
+  'G': {".##", "#..", "#.#", "#.#", ".##"},
+  'H': {"#.#", "#.#", "###", "#.#", "#.#"},
+  'I': {"###", ".#.", ".#.", ".#.", "###"},
+  'J': {"..#", "..#", "..#", "#.#", ".#."},
+  'K': {"#.#", "#.#", "##.", "#.#", "#.#"},
+  'L': {"#..", "#..", "#..", "#..", "###"},
+  'M': {"#.#", "###", "###", "#.#", "#.#"},
+  'N': {"##.", "#.#", "#.#", "#.#", "#.#"},
+  'O': {".#.", "#.#", "#.#", "#.#", ".#."},
+  'P': {"##.", "#.#", "##.", "#..", "#.."},
+  'Q': {".#.", "#.#", "#.#", "##.", ".##"},
+  'R': {"##.", "#.#", "##.", "#.#", "#.#"},
+  'S': {".##", "#..", ".#.", "..#", "##."},
+  'T': {"###", ".#.", ".#.", ".#.", ".#."},
+  'U': {"#.#", "#.#", "#.#", "#.#", "###"},
+  'V': {"#.#", "#.#", "#.#", "#.#", ".#."},
+  'W': {"#.#", "#.#", "###", "###", "#.#"},
+  'X': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+  'Y': {"#.#", "#.#", ".#.", ".#.", ".#."},
+  'Z': {"###", "..#", ".#.", "#..", "###"},
+  '0': {"###", "#.#", "#.#", "#.#", "###"},
+  '1': {".#.", "##.", ".#.", ".#.", "###"},
+  '2': {"##.", "..#", ".#.", "#..", "###"},
+  '3': {"##.", "..#", ".#.", "..#", "##."},
+  '4': {"#.#", "#.#", "###", "..#", "..#"},
+  '5': {"###", "#..", "##.", "..#", "##."},
+  '6': {".##", "#..", "###", "#.#", "###"},
+  '7': {"###", "..#", ".#.", ".#.", ".#."},
+  '8': {"###", "#.#", "###", "#.#", "###"},
+  '9': {"###", "#.#", "###", "..#", "##."},
+  '.': {"...", "...", "...", "...", ".#."},
+  ',': {"...", "...", "...", ".#.", "#.."},
+  ':': {"...", ".#.", "...", ".#.", "..."},
+  '-': {"...", "...", "###", "...", "..."},
+  '/': {"..#", "..#", ".#.", "#..", "#.."},
+  ' ': {"...", "...", "...", "...", "..."},
+}
+
+// Draw text on m in c with its top left at x, y, in capitals, skipping
+// characters the font lacks
+func drawText(m draw.Image, x, y int, text string, c color.Color) {
+  for _, r := range strings.ToUpper(text) {
+    g, ok := glyphs[r]
+    if !ok {
+      continue
+    }
+    for gy, row := range g {
+      for gx, px := range row {
+        if px != '#' {
+          continue
+        }
+        for sy := 0; sy < labelScale; sy++ {
+          for sx := 0; sx < labelScale; sx++ {
+            m.Set(x + gx * labelScale + sx, y + gy * labelScale + sy, c)
+          }
+        }
+      }
+    }
+    x += 4 * labelScale // A column of space between glyphs
+  }
+}
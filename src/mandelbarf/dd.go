@@ -0,0 +1,119 @@
+package main
+
+import "math"
+
+// Double-double arithmetic: a number is the unevaluated sum of two float64s,
+// hi + lo with |lo| at most half an ulp of hi, for about 106 bits of
+// mantissa. It's several times slower than float64 but far faster than
+// arbitrary precision, and it keeps pixels apart well past where float64
+// rounds them together.
+type dd struct{ hi, lo float64 }
+
+// a + b exactly, as the rounded sum and its error
+func twoSum(a, b float64) dd {
+  s := a + b
+  v := s - a
+  return dd{s, (a - (s - v)) + (b - v)}
+}
+
+// Like twoSum, but only for |a| >= |b|
+func quickTwoSum(a, b float64) dd {
+  s := a + b
+  return dd{s, b - (s - a)}
+}
+
+// a * b exactly, as the rounded product and its error
+func twoProd(a, b float64) dd {
+  p := a * b
+  return dd{p, math.FMA(a, b, -p)}
+}
+
+func (a dd) add(b dd) dd {
+  s := twoSum(a.hi, b.hi)
+  t := twoSum(a.lo, b.lo)
+  s = quickTwoSum(s.hi, s.lo + t.hi)
+  return quickTwoSum(s.hi, s.lo + t.lo)
+}
+
+func (a dd) sub(b dd) dd {
+  return a.add(dd{-b.hi, -b.lo})
+}
+
+func (a dd) mul(b dd) dd {
+  p := twoProd(a.hi, b.hi)
+  return quickTwoSum(p.hi, p.lo + a.hi * b.lo + a.lo * b.hi)
+}
+
+// Like mandelbrot, but iterating in double-double from c = re + im i. The
+// escape test only needs the high parts, so it's made on them by norm. With
+// farthest, a c that never escapes ends at the farthest point of its orbit,
+// as by escapeFarthest.
+func mandelbrotDD(re, im dd, maxIter int, radius float64, norm func(z complex128) float64, farthest bool) (int, complex128) {
+  zr, zi := re, im
+  far, farNorm := complex(re.hi, im.hi), norm(complex(re.hi, im.hi))
+  var i int
+  for i = 0; i < maxIter; i++ {
+    rr, ii, ri := zr.mul(zr), zi.mul(zi), zr.mul(zi)
+    zr = rr.sub(ii).add(re)
+    zi = dd{2 * ri.hi, 2 * ri.lo}.add(im)
+    n := norm(complex(zr.hi, zi.hi))
+    if n > radius {
+      break
+    }
+    if farthest && n > farNorm {
+      far, farNorm = complex(zr.hi, zi.hi), n
+    }
+  }
+  if farthest && i == maxIter {
+    return i, far
+  }
+  return i, complex(zr.hi, zi.hi)
+}
+
+// The arithmetic cfg renders in, "float64" or "dd", resolving "auto": dd
+// for the mandelbrot set once neighboring pixels are fewer than
+// precisionWarn float64s apart, where float64 blocks up
+func (cfg RenderConfig) precision() string {
+  if cfg.Precision != "" && cfg.Precision != "auto" {
+    return cfg.Precision
+  }
+  if cfg.Fractal == "mandelbrot" && cfg.pixelPrecision() < precisionWarn {
+    return "dd"
+  }
+  return "float64"
+}
+
+// The kernel at x,y in a cols x rows grid of pixels over the view, after
+// mapping x,y by pre, or nil if cfg's fractal has none. In double-double,
+// points are found as offsets from the center of the view, which float64
+// has plenty of precision for, and the center is added in double-double.
+func (cfg RenderConfig) pixelKernel(cols, rows int, pre affine) func(x, y float64, maxIter int, radius float64) (int, complex128) {
+  if cfg.precision() == "dd" {
+    cx, cy := (cfg.XMin + cfg.XMax) / 2, (cfg.YMin + cfg.YMax) / 2
+    m := cfg.viewTransform(cols, rows, cx, cy).compose(pre)
+    norm := cfg.norm()
+    farthest := cfg.InteriorColoring == "depth"
+    return func(x, y float64, maxIter int, radius float64) (int, complex128) {
+      dx, dy := m.apply(x, y)
+      return mandelbrotDD(twoSum(cx, dx), twoSum(cy, dy), maxIter, radius, norm, farthest)
+    }
+  }
+  kernel := cfg.kernel()
+  if kernel == nil {
+    return nil
+  }
+  m := cfg.pixelTransform(cols, rows)
+  if pre != identity {
+    m = m.compose(pre)
+  }
+  return func(x, y float64, maxIter int, radius float64) (int, complex128) {
+    re, im := m.apply(x, y)
+    return kernel(complex(re, im), maxIter, radius)
+  }
+}
+
+// pixelKernel for output pixel x,y, sampled at its center
+func (cfg RenderConfig) outputKernel() func(x, y float64, maxIter int, radius float64) (int, complex128) {
+  cols, rows := cfg.renderSize()
+  return cfg.pixelKernel(cols, rows, cfg.outputScale())
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "log/slog"
+  "math"
+)
+
+const dimensionGrid = 2048 // Default columns of the finest grid
+const minBoxes = 8 // Coarsest grid to count, in boxes across
+
+// Which points of a cols x rows grid over the view never escape
+func membership(cfg RenderConfig, cols, rows int) ([]bool, error) {
+  kernel := cfg.pixelKernel(cols, rows, identity)
+  if kernel == nil {
+    return nil, fmt.Errorf("%s has no escape-time membership to measure", cfg.Fractal)
+  }
+  radius := cfg.escapeRadius()
+  inside := make([]bool, cols * rows)
+  queue := make(chan int, rows)
+  for r := 0; r < rows; r++ {
+    queue <- r
+  }
+  close(queue)
+  flags := make(chan int, cfg.Workers)
+  for w := 0; w < cfg.Workers; w++ {
+    go func() {
+      for r := range queue {
+        for c := 0; c < cols; c++ {
+          v, _ := kernel(float64(c), float64(r), cfg.Iterations, radius)
+          inside[r * cols + c] = v == cfg.Iterations
+        }
+      }
+      flags <- 0
+    }()
+  }
+  for w := 0; w < cfg.Workers; w++ {
+    <- flags
+  }
+  return inside, nil
+}
+
+// How many size x size boxes of the grid hold points both inside and outside
+func boundaryBoxes(inside []bool, cols, rows, size int) int {
+  n := 0
+  for by := 0; by < rows; by += size {
+    for bx := 0; bx < cols; bx += size {
+      in, out := false, false
+      for r := by; r < min(by + size, rows) && !(in && out); r++ {
+        for c := bx; c < min(bx + size, cols); c++ {
+          if inside[r * cols + c] {
+            in = true
+          } else {
+            out = true
+          }
+        }
+      }
+      if in && out {
+        n++
+      }
+    }
+  }
+  return n
+}
+
+// Least squares fit of y = slope * x + b, and its coefficient of determination
+func fitLine(x, y []float64) (slope, r2 float64) {
+  n := float64(len(x))
+  var sx, sy, sxx, sxy, syy float64
+  for i := range x {
+    sx += x[i]
+    sy += y[i]
+    sxx += x[i] * x[i]
+    sxy += x[i] * y[i]
+    syy += y[i] * y[i]
+  }
+  cov := sxy - sx * sy / n
+  varX := sxx - sx * sx / n
+  varY := syy - sy * sy / n
+  slope = cov / varX
+  r2 = cov * cov / (varX * varY)
+  return
+}
+
+// Estimate the box-counting dimension of the boundary of the set in view.
+// Membership is computed once on the finest grid; coarser grids count boxes
+// of 2, 4, 8... grid points on a side. If halving the box size multiplies
+// the number of boxes crossing the boundary by 2^d, the dimension is d.
+func dimensionCmd(args []string) error {
+  fs := flag.NewFlagSet("dimension", flag.ExitOnError)
+  config := renderFlags(fs)
+  verbosity := logFlags(fs)
+  grid := fs.Int("grid", dimensionGrid, "columns of the finest grid of points")
+  fs.Parse(args)
+  verbosity()
+  cfg, err := config()
+  if err != nil {
+    return err
+  }
+  if err := cfg.validate(); err != nil {
+    return err
+  }
+  cols := *grid
+  rows := max(1, cols * cfg.Rows / cfg.Cols)
+  if min(cols, rows) < 2 * minBoxes {
+    return fmt.Errorf("grid of %dx%d is too coarse", cols, rows)
+  }
+
+  inside, err := membership(cfg, cols, rows)
+  if err != nil {
+    return err
+  }
+  var logScale, logCount []float64
+  for size := 2; min(cols, rows) / size >= minBoxes; size *= 2 {
+    n := boundaryBoxes(inside, cols, rows, size)
+    slog.Info("boxes", "size", size, "boundary", n)
+    if n == 0 {
+      continue
+    }
+    logScale = append(logScale, math.Log(float64(cols) / float64(size)))
+    logCount = append(logCount, math.Log(float64(n)))
+  }
+  if len(logScale) < 2 {
+    return fmt.Errorf("no boundary in view: no boxes hold points both inside and outside the set")
+  }
+  d, r2 := fitLine(logScale, logCount)
+  fmt.Printf("dimension %.4f (R² %.4f over %d box sizes)\n", d, r2, len(logScale))
+  return nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+  "bytes"
+  "compress/zlib"
+  "encoding/binary"
+  "fmt"
+  "hash/crc32"
+  "image"
+  "image/color"
+  "image/png"
+  "io"
+)
+
+// Length of the PNG signature and the IHDR chunk, which always comes first
+const pngHeaderLen = 8 + 4 + 4 + 13 + 4
+
+// PNG chunks tagging the color space: "srgb" is an sRGB chunk, "full" adds
+// the gAMA and cHRM chunks the PNG spec recommends for decoders that don't
+// understand sRGB, and "none" is nothing
+func colorTagChunks(tag string) ([]byte, error) {
+  var chunks bytes.Buffer
+  switch tag {
+  case "none":
+  case "full":
+    writeChunk(&chunks, "sRGB", []byte{0}) // Perceptual rendering intent
+    writeChunk(&chunks, "gAMA", be32(45455))
+    writeChunk(&chunks, "cHRM", be32(31270, 32900, 64000, 33000, 30000, 60000, 15000, 6000))
+  case "srgb":
+    writeChunk(&chunks, "sRGB", []byte{0})
+  default:
+    return nil, fmt.Errorf("unknown color tag %q", tag)
+  }
+  return chunks.Bytes(), nil
+}
+
+// How to encode a PNG
+type pngOptions struct {
+  tag string // Color space tag, as by colorTagChunks
+  interlace bool // Adam7-interlace
+  level png.CompressionLevel
+  config *RenderConfig // If set, embedded for -continue
+}
+
+// Names for -compression
+var compressionLevels = map[string]png.CompressionLevel{
+  "default": png.DefaultCompression,
+  "fast": png.BestSpeed,
+  "best": png.BestCompression,
+  "none": png.NoCompression,
+}
+
+// Parse the PNG flags, checking them before any rendering is done
+func parsePNGOptions(tag string, interlace bool, compression string) (pngOptions, error) {
+  if _, err := colorTagChunks(tag); err != nil {
+    return pngOptions{}, err
+  }
+  level, ok := compressionLevels[compression]
+  if !ok {
+    return pngOptions{}, fmt.Errorf("unknown compression %q", compression)
+  }
+  return pngOptions{tag, interlace, level, nil}, nil
+}
+
+// The ancillary chunks opts asks for, which go after IHDR
+func (opts pngOptions) chunks() ([]byte, error) {
+  chunks, err := colorTagChunks(opts.tag)
+  if err != nil || opts.config == nil {
+    return chunks, err
+  }
+  config, err := configChunk(*opts.config)
+  if err != nil {
+    return nil, err
+  }
+  return append(chunks, config...), nil
+}
+
+// Encode m to w as a PNG as opts says. Go's encoder doesn't write ancillary
+// chunks, so they're spliced in after IHDR.
+func encodePNG(w io.Writer, m image.Image, opts pngOptions) error {
+  chunks, err := opts.chunks()
+  if err != nil {
+    return err
+  }
+  if opts.interlace {
+    return encodeInterlaced(w, m, chunks, opts.level)
+  }
+  var buf bytes.Buffer
+  enc := png.Encoder{CompressionLevel: opts.level}
+  if err := enc.Encode(&buf, m); err != nil {
+    return err
+  }
+  data := buf.Bytes()
+  for _, part := range [][]byte{data[:pngHeaderLen], chunks, data[pngHeaderLen:]} {
+    if _, err := w.Write(part); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Adam7 passes: the first pixel and the spacing between pixels, in columns
+// and rows. Each pass fills in between the pixels of the ones before, so a
+// browser can show the whole image coarsely from the first 1/64 of it.
+var adam7 = [7]struct{ x, y, dx, dy int }{
+  {0, 0, 8, 8}, {4, 0, 8, 8}, {0, 4, 4, 8}, {2, 0, 4, 4},
+  {0, 2, 2, 4}, {1, 0, 2, 2}, {0, 1, 1, 2},
+}
+
+// Go's encoder can't interlace, so write the whole PNG: 8-bit RGB if m is
+// opaque or RGBA if not, with extra chunks after IHDR
+func encodeInterlaced(w io.Writer, m image.Image, chunks []byte, level png.CompressionLevel) error {
+  b := m.Bounds()
+  opaque := true
+  for y := b.Min.Y; y < b.Max.Y && opaque; y++ {
+    for x := b.Min.X; x < b.Max.X; x++ {
+      if _, _, _, a := m.At(x, y).RGBA(); a != 0xffff {
+        opaque = false
+        break
+      }
+    }
+  }
+  bpp, colorType := 4, byte(6)
+  if opaque {
+    bpp, colorType = 3, 2
+  }
+
+  var data bytes.Buffer
+  z, err := zlib.NewWriterLevel(&data, zlibLevel(level))
+  if err != nil {
+    return err
+  }
+  for _, pass := range adam7 {
+    cols := (b.Dx() - pass.x + pass.dx - 1) / pass.dx
+    rows := (b.Dy() - pass.y + pass.dy - 1) / pass.dy
+    if cols <= 0 || rows <= 0 {
+      continue
+    }
+    prev := make([]byte, cols * bpp) // Zero above the first row
+    cur := make([]byte, cols * bpp)
+    for r := 0; r < rows; r++ {
+      for c := 0; c < cols; c++ {
+        x, y := b.Min.X + pass.x + c * pass.dx, b.Min.Y + pass.y + r * pass.dy
+        nc := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+        copy(cur[c * bpp:], []byte{nc.R, nc.G, nc.B, nc.A}[:bpp])
+      }
+      if _, err := z.Write(filterRow(cur, prev, bpp)); err != nil {
+        return err
+      }
+      prev, cur = cur, prev
+    }
+  }
+  if err := z.Close(); err != nil {
+    return err
+  }
+
+  var out bytes.Buffer
+  out.WriteString("\x89PNG\r\n\x1a\n")
+  ihdr := append(be32(uint32(b.Dx()), uint32(b.Dy())), 8, colorType, 0, 0, 1)
+  writeChunk(&out, "IHDR", ihdr)
+  out.Write(chunks)
+  writeChunk(&out, "IDAT", data.Bytes())
+  writeChunk(&out, "IEND", nil)
+  _, err = out.WriteTo(w)
+  return err
+}
+
+const idatSize = 1 << 15 // Write a pngRowWriter's compressed data in IDAT chunks of about this size
+
+// Encodes an opaque PNG a row at a time, so it can be written while the rest
+// of the image renders. Go's encoder needs the whole image at once, so this
+// writes 8-bit RGB itself, filtering rows as encodeInterlaced does.
+type pngRowWriter struct {
+  w io.Writer
+  z *zlib.Writer
+  idat bytes.Buffer // Compressed data not yet written out
+  prev, cur []byte
+}
+
+// Start a cols by rows PNG on w, encoded as opts says, which mustn't
+// interlace
+func newPNGRowWriter(w io.Writer, cols, rows int, opts pngOptions) (*pngRowWriter, error) {
+  chunks, err := opts.chunks()
+  if err != nil {
+    return nil, err
+  }
+  var head bytes.Buffer
+  head.WriteString("\x89PNG\r\n\x1a\n")
+  writeChunk(&head, "IHDR", append(be32(uint32(cols), uint32(rows)), 8, 2, 0, 0, 0))
+  head.Write(chunks)
+  if _, err := head.WriteTo(w); err != nil {
+    return nil, err
+  }
+  p := &pngRowWriter{w: w, prev: make([]byte, 3 * cols), cur: make([]byte, 3 * cols)}
+  p.z, err = zlib.NewWriterLevel(&p.idat, zlibLevel(opts.level))
+  return p, err
+}
+
+// Encode the next row, whose pixels must be opaque
+func (p *pngRowWriter) row(pixels []color.RGBA) error {
+  for i, c := range pixels {
+    copy(p.cur[3 * i:], []byte{c.R, c.G, c.B})
+  }
+  if _, err := p.z.Write(filterRow(p.cur, p.prev, 3)); err != nil {
+    return err
+  }
+  p.prev, p.cur = p.cur, p.prev
+  if p.idat.Len() >= idatSize {
+    return p.flush()
+  }
+  return nil
+}
+
+// Write the compressed data so far as an IDAT chunk
+func (p *pngRowWriter) flush() error {
+  var chunk bytes.Buffer
+  writeChunk(&chunk, "IDAT", p.idat.Bytes())
+  p.idat.Reset()
+  _, err := chunk.WriteTo(p.w)
+  return err
+}
+
+// Finish the PNG once every row is written
+func (p *pngRowWriter) close() error {
+  if err := p.z.Close(); err != nil {
+    return err
+  }
+  if err := p.flush(); err != nil {
+    return err
+  }
+  var end bytes.Buffer
+  writeChunk(&end, "IEND", nil)
+  _, err := end.WriteTo(p.w)
+  return err
+}
+
+// The zlib level matching level, as Go's encoder maps them
+func zlibLevel(level png.CompressionLevel) int {
+  switch level {
+  case png.NoCompression:
+    return zlib.NoCompression
+  case png.BestSpeed:
+    return zlib.BestSpeed
+  case png.BestCompression:
+    return zlib.BestCompression
+  }
+  return zlib.DefaultCompression
+}
+
+// Filter a scanline given the one above it, choosing the filter type that
+// leaves the smallest residuals, as Go's encoder does. Returns the filter
+// type byte followed by the filtered row.
+func filterRow(cur, prev []byte, bpp int) []byte {
+  var best []byte
+  bestSum := -1
+  for ft := byte(0); ft <= 4; ft++ {
+    f := make([]byte, 1 + len(cur))
+    f[0] = ft
+    sum := 0
+    for i, x := range cur {
+      var a, up, c byte // Left, above, and above left
+      if i >= bpp {
+        a, c = cur[i - bpp], prev[i - bpp]
+      }
+      up = prev[i]
+      var pred byte
+      switch ft {
+      case 1:
+        pred = a
+      case 2:
+        pred = up
+      case 3:
+        pred = byte((int(a) + int(up)) / 2)
+      case 4:
+        pred = paeth(a, up, c)
+      }
+      f[1 + i] = x - pred
+      sum += absInt(int(int8(f[1 + i])))
+    }
+    if bestSum < 0 || sum < bestSum {
+      best, bestSum = f, sum
+    }
+  }
+  return best
+}
+
+func paeth(a, b, c byte) byte {
+  p := int(a) + int(b) - int(c)
+  pa, pb, pc := absInt(p - int(a)), absInt(p - int(b)), absInt(p - int(c))
+  if pa <= pb && pa <= pc {
+    return a
+  }
+  if pb <= pc {
+    return b
+  }
+  return c
+}
+
+func absInt(x int) int {
+  if x < 0 {
+    return -x
+  }
+  return x
+}
+
+func writeChunk(w *bytes.Buffer, kind string, data []byte) {
+  w.Write(be32(uint32(len(data))))
+  crc := crc32.NewIEEE()
+  crc.Write([]byte(kind))
+  crc.Write(data)
+  w.WriteString(kind)
+  w.Write(data)
+  w.Write(be32(crc.Sum32()))
+}
+
+// Big-endian encoding of v
+func be32(v ...uint32) []byte {
+  b := make([]byte, 0, 4 * len(v))
+  for _, x := range v {
+    b = binary.BigEndian.AppendUint32(b, x)
+  }
+  return b
+}
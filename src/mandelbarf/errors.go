@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// A RenderError is a failure to render Config, wrapping the cause
+type RenderError struct {
+  Config RenderConfig
+  Err error
+}
+
+func (e *RenderError) Error() string {
+  return fmt.Sprintf("render %s: %v", e.Config.describe(), e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+  return e.Err
+}
+
+// An EncodeError is a failure to encode or write an image to Path
+type EncodeError struct {
+  Path string
+  Err error
+}
+
+func (e *EncodeError) Error() string {
+  return fmt.Sprintf("encode %s: %v", e.Path, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+  return e.Err
+}
+
+// The settings of cfg most likely to explain a failure, briefly
+func (cfg RenderConfig) describe() string {
+  scale := fmt.Sprintf("%dx%d", cfg.ScaleX, cfg.ScaleY)
+  if cfg.Supersample > 0 {
+    scale = fmt.Sprintf("%g", cfg.Supersample)
+  }
+  return fmt.Sprintf("%s %g,%g,%g,%g at %dx%d scale %s, %d iterations, %d workers",
+    cfg.Fractal, cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax, cfg.Cols, cfg.Rows,
+    scale, cfg.Iterations, cfg.Workers)
+}
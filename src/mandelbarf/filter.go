@@ -0,0 +1,145 @@
+package main
+
+import (
+  "image/color"
+  "math"
+)
+
+const lanczosA = 3 // Default lobes for the lanczos filter
+
+// The weights of the input samples contributing to one output sample
+type taps struct {
+  start int // First input sample
+  w []float64
+}
+
+// Lanczos-windowed sinc with a lobes, in units of output pixels
+func lanczos(x float64, a int) float64 {
+  if x == 0 {
+    return 1
+  }
+  if math.Abs(x) >= float64(a) {
+    return 0
+  }
+  px := math.Pi * x
+  return float64(a) * math.Sin(px) * math.Sin(px / float64(a)) / (px * px)
+}
+
+// For each of out outputs, the lanczos weights of the n inputs, n >= out.
+// Each output covers a window a output pixels either side of its center;
+// near the edges the window is cut off and the remaining weights
+// renormalized. With wrap, it runs past the edges instead, to inputs before
+// 0 and from n on that stand for those at the other edge.
+func lanczosTaps(n, out, a int, wrap bool) []taps {
+  scale := float64(n) / float64(out)
+  t := make([]taps, out)
+  for i := range t {
+    center := (float64(i) + 0.5) * scale // In input samples
+    start := int(math.Floor(center - float64(a) * scale))
+    stop := int(math.Ceil(center + float64(a) * scale))
+    if !wrap {
+      start, stop = max(0, start), min(n, stop)
+    }
+    w := make([]float64, stop - start)
+    sum := 0.0
+    for j := range w {
+      w[j] = lanczos((float64(start + j) + 0.5 - center) / scale, a)
+      sum += w[j]
+    }
+    for j := range w {
+      w[j] /= sum
+    }
+    t[i] = taps{start, w}
+  }
+  return t
+}
+
+// Downsamples with a lanczos filter, which is sharper than a box average yet
+// aliases less. The filter is separable, so each input row is filtered
+// horizontally once, into a ring of as many rows as the vertical filter
+// spans, and output rows are filtered vertically from the ring. Rows must
+// be asked for in order.
+type lanczosScaler struct {
+  in img
+  hTaps, vTaps []taps
+  ring [][]float64 // Horizontally filtered input rows, row r at r % len(ring)
+  next int // Next input row to filter
+  linear bool // Filter in linear light
+  src [][4]float64 // The channels of the input row being filtered, from column -pad
+  pad int // Columns wrapped around from the right edge to the left of src
+}
+
+// Scale in down to cols x rows. With wrapX, the left and right edges of in
+// are filtered as neighbors, as if it tiled horizontally.
+func newLanczosScaler(in img, cols, rows, a int, linear, wrapX bool) *lanczosScaler {
+  s := lanczosScaler{
+    in: in,
+    hTaps: lanczosTaps(in.cols, cols, a, wrapX),
+    vTaps: lanczosTaps(in.rows, rows, a, false),
+    linear: linear,
+  }
+  width := in.cols
+  for _, t := range s.hTaps {
+    s.pad = max(s.pad, -t.start)
+    width = max(width, t.start + len(t.w))
+  }
+  for i := range s.hTaps {
+    s.hTaps[i].start += s.pad
+  }
+  s.src = make([][4]float64, s.pad + width)
+  ringRows := 0
+  for _, t := range s.vTaps {
+    ringRows = max(ringRows, len(t.w))
+  }
+  s.ring = make([][]float64, ringRows)
+  for i := range s.ring {
+    s.ring[i] = make([]float64, len(s.hTaps) * 4)
+  }
+  return &s
+}
+
+func (s *lanczosScaler) need(outRow int) int {
+  v := s.vTaps[outRow]
+  return v.start + len(v.w)
+}
+
+func (s *lanczosScaler) row(outRow int, out []color.RGBA) {
+  v := s.vTaps[outRow]
+  s.next = max(s.next, v.start)
+  for ; s.next < v.start + len(v.w); s.next++ {
+    h := s.ring[s.next % len(s.ring)]
+    for i := range s.src {
+      col := ((i - s.pad) % s.in.cols + s.in.cols) % s.in.cols
+      s.src[i] = channels(s.in.px[s.next * s.in.cols + col], s.linear)
+    }
+    for outCol, t := range s.hTaps {
+      var sum [4]float64
+      for j, w := range t.w {
+        c := s.src[t.start + j]
+        for k := range sum {
+          sum[k] += w * c[k]
+        }
+      }
+      copy(h[outCol * 4:], sum[:])
+    }
+  }
+  for outCol := range out {
+    var sum [4]float64
+    for j, w := range v.w {
+      h := s.ring[(v.start + j) % len(s.ring)][outCol * 4:]
+      for k := range sum {
+        sum[k] += w * h[k]
+      }
+    }
+    out[outCol] = fromChannels(sum, s.linear)
+  }
+}
+
+// The negative lobes can overshoot, so clamp to a valid premultiplied color
+func clampPremultiplied(c [4]float64) color.RGBA {
+  alpha := math.Round(math.Max(0, math.Min(255, c[3])))
+  channel := func(v float64) uint8 {
+    return uint8(math.Round(math.Max(0, math.Min(alpha, v))))
+  }
+  return color.RGBA{channel(c[0]), channel(c[1]), channel(c[2]), uint8(alpha)}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+  "image"
+  "image/color"
+  "math"
+  "testing"
+)
+
+// At scales that aren't whole, each output's weights still sum to 1 and
+// stay within the input
+func TestLanczosTapsUneven(t *testing.T) {
+  for _, size := range [][2]int{{153, 61}, {93, 37}, {101, 100}, {7, 3}, {13, 1}} {
+    n, out := size[0], size[1]
+    for i, tap := range lanczosTaps(n, out, lanczosA, false) {
+      sum := 0.0
+      for _, w := range tap.w {
+        sum += w
+      }
+      if math.Abs(sum - 1) > 1e-9 {
+        t.Errorf("%d to %d, output %d: weights sum to %g", n, out, i, sum)
+      }
+      if tap.start < 0 || tap.start + len(tap.w) > n {
+        t.Errorf("%d to %d, output %d: taps %d to %d run outside the input", n, out, i, tap.start, tap.start + len(tap.w))
+      }
+    }
+  }
+
+  solid := checkerboard(97, 71, 1, color.RGBA{40, 90, 200, 255}, color.RGBA{40, 90, 200, 255})
+  m, err := Resize(solid, 61, 37)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for y := 0; y < 37; y++ {
+    for x := 0; x < 61; x++ {
+      if c := m.RGBAAt(x, y); c != solid.RGBAAt(0, 0) {
+        t.Fatalf("resized solid image is %v at %d,%d, want %v", c, x, y, solid.RGBAAt(0, 0))
+      }
+    }
+  }
+}
+
+// A fractional supersample renders sizes no scale divides to exactly that
+// size, looking much as a whole supersample does
+func TestFractionalSupersample(t *testing.T) {
+  for _, size := range [][2]int{{61, 37}, {97, 13}, {7, 5}} {
+    ref := testConfig(t, "-scale", "4")
+    ref.Cols, ref.Rows = size[0], size[1]
+    want := testRender(t, ref)
+    for _, scale := range []string{"1.3", "2.5", "3.7"} {
+      cfg := testConfig(t, "-scale", scale)
+      cfg.Cols, cfg.Rows = size[0], size[1]
+      m, err := Render(cfg)
+      if err != nil {
+        t.Fatalf("%dx%d at %s: %v", size[0], size[1], scale, err)
+      }
+      if m.Bounds() != image.Rect(0, 0, size[0], size[1]) {
+        t.Fatalf("%dx%d at %s: bounds %v", size[0], size[1], scale, m.Bounds())
+      }
+      diff := 0.0
+      for i, w := range want {
+        c := color.RGBAModel.Convert(m.At(i % size[0], i / size[0])).(color.RGBA)
+        diff += math.Abs(float64(c.R) - float64(w.R)) + math.Abs(float64(c.G) - float64(w.G)) + math.Abs(float64(c.B) - float64(w.B))
+      }
+      if diff /= float64(3 * len(want)); diff > 4 {
+        t.Errorf("%dx%d at %s: differs from 4x by %.1f a channel on average", size[0], size[1], scale, diff)
+      }
+    }
+  }
+}
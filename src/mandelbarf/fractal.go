@@ -0,0 +1,246 @@
+package main
+
+import (
+  "math"
+  "math/cmplx"
+)
+
+// A Fractal is an escape-time iteration. Init gives the starting z and the
+// constant c for a point in the plane, and Step takes z one iteration on. A
+// point escapes once |z| exceeds the escape radius.
+type Fractal interface {
+  Init(p complex128) (z, c complex128)
+  Step(z, c complex128) complex128
+}
+
+type fractal struct {
+  newFractal func(cfg RenderConfig) Fractal // Escape-time iteration, if it has one
+  bounds [4]float64 // Default xMin, xMax, yMin, yMax
+  iterations int // Iteration cap at the default bounds
+}
+
+// Newton's method converges fast, and after 64 steps a point's shade is
+// black anyway
+var fractals = map[string]fractal{
+  "mandelbrot": {fixed(mandelbrotFractal{}), [4]float64{xMin, xMax, yMin, yMax}, 256},
+  "tricorn": {fixed(tricornFractal{}), [4]float64{-2.9, 1.9, -1.6, 1.6}, 256},
+  "burningship": {fixed(burningShipFractal{}), [4]float64{-2.3, 1.3, -1.75, 0.65}, 256},
+  "julia": {newJuliaFractal, [4]float64{-1.8, 1.8, -1.2, 1.2}, 256},
+  "multibrot": {newMultibrotFractal, [4]float64{-2.1, 2.1, -1.4, 1.4}, 256},
+  "newton": {nil, [4]float64{-1.5, 1.5, -1.0, 1.0}, 64},
+  "buddhabrot": {nil, [4]float64{-2.25, 1.05, -1.1, 1.1}, 256},
+}
+
+// Make a fractal available to -fractal as name, framed by bounds (xMin,
+// xMax, yMin, yMax) by default, with iterations as the cap there.
+// newFractal is called once per render with its configuration.
+func RegisterFractal(name string, newFractal func(cfg RenderConfig) Fractal, bounds [4]float64, iterations int) {
+  fractals[name] = fractal{newFractal, bounds, iterations}
+}
+
+// A newFractal for a Fractal with no settings
+func fixed(f Fractal) func(cfg RenderConfig) Fractal {
+  return func(RenderConfig) Fractal {
+    return f
+  }
+}
+
+// Ways for the escape test to measure z. Under L-infinity and L1 the region
+// inside the radius is a square, which changes the shapes of the bands.
+var norms = map[string]func(z complex128) float64{
+  "l2": cmplx.Abs,
+  "linf": func(z complex128) float64 {
+    return max(math.Abs(real(z)), math.Abs(imag(z)))
+  },
+  "l1": func(z complex128) float64 {
+    return math.Abs(real(z)) + math.Abs(imag(z))
+  },
+}
+
+// The norm cfg's escape test measures z by
+func (cfg RenderConfig) norm() func(z complex128) float64 {
+  if cfg.Norm == "" {
+    return cmplx.Abs
+  }
+  return norms[cfg.Norm]
+}
+
+// Return the number of iterations of f before the point p gets "far away"
+// by norm, or maxIter if it never does, and the last z
+func escape(f Fractal, p complex128, maxIter int, radius float64, norm func(z complex128) float64) (int, complex128) {
+  z, c := f.Init(p)
+  var i int
+  for i = 0; i < maxIter; i++ {
+    z = f.Step(z, c)
+    if norm(z) > radius {
+      break
+    }
+  }
+  return i, z
+}
+
+// Like escape, but if p never escapes, returning the point of its orbit
+// farthest out by norm rather than the last. An orbit passing 2 escapes, so
+// how far short of 2 it stays says how deep inside the set p is.
+func escapeFarthest(f Fractal, p complex128, maxIter int, radius float64, norm func(z complex128) float64) (int, complex128) {
+  z, c := f.Init(p)
+  far, farNorm := z, norm(z)
+  var i int
+  for i = 0; i < maxIter; i++ {
+    z = f.Step(z, c)
+    n := norm(z)
+    if n > radius {
+      return i, z
+    }
+    if n > farNorm {
+      far, farNorm = z, n
+    }
+  }
+  return i, far
+}
+
+const maxCyclePeriod = 1024 // Longest attracting cycle interiorDistance looks for
+const cycleTolerance = 1e-10 // How near an orbit must come back to count as a cycle
+
+// An estimate of the distance from c, inside the mandelbrot set, to its
+// boundary, from z, a point of c's orbit long enough in to be on its
+// attracting cycle, or -1 if no such cycle turns up within maxIter steps of
+// z or maxCyclePeriod. Only points whose orbits settle on a cycle have one:
+// those in the hyperbolic components, as nearly all of the interior is. For
+// the cycle z0 ... zp = z0 of f = z^2 + c, with f' its derivatives over the
+// cycle, it's (1 - |f'z|^2) / |f'cz + f'zz f'c / (1 - f'z)|.
+func interiorDistance(c, z complex128, maxIter int) float64 {
+  period := 0
+  w := z
+  for p := 1; p <= min(maxIter, maxCyclePeriod); p++ {
+    w = w * w + c
+    if cmplx.Abs(w - z) < cycleTolerance {
+      period = p
+      break
+    }
+  }
+  if period == 0 {
+    return -1
+  }
+  dz, dc, dzz, dcz := complex(1, 0), complex(0, 0), complex(0, 0), complex(0, 0)
+  for i := 0; i < period; i++ {
+    dcz = 2 * (z * dcz + dc * dz)
+    dzz = 2 * (dz * dz + z * dzz)
+    dc = 2 * z * dc + 1
+    dz = 2 * z * dz
+    z = z * z + c
+  }
+  r := cmplx.Abs(dz)
+  if r >= 1 {
+    return -1
+  }
+  return (1 - r * r) / cmplx.Abs(dcz + dzz * dc / (1 - dz))
+}
+
+// The escape-time kernel of cfg's fractal, or nil if it isn't one. The
+// mandelbrot set under the L2 norm, the default, has a loop of its own that
+// saves the calls per iteration. For depth interior coloring, points that
+// never escape end at the farthest point of their orbit.
+func (cfg RenderConfig) kernel() func(c complex128, maxIter int, radius float64) (int, complex128) {
+  newFractal := fractals[cfg.Fractal].newFractal
+  if newFractal == nil {
+    return nil
+  }
+  f := newFractal(cfg)
+  norm := cfg.norm()
+  if cfg.InteriorColoring == "depth" {
+    return func(p complex128, maxIter int, radius float64) (int, complex128) {
+      return escapeFarthest(f, p, maxIter, radius, norm)
+    }
+  }
+  if _, ok := f.(mandelbrotFractal); ok && (cfg.Norm == "" || cfg.Norm == "l2") {
+    return mandelbrot
+  }
+  return func(p complex128, maxIter int, radius float64) (int, complex128) {
+    return escape(f, p, maxIter, radius, norm)
+  }
+}
+
+// z -> z^2 + c from z = c
+type mandelbrotFractal struct{}
+
+func (mandelbrotFractal) Init(p complex128) (z, c complex128) {
+  return p, p
+}
+
+func (mandelbrotFractal) Step(z, c complex128) complex128 {
+  return z*z + c
+}
+
+// Return the number of iterations before the point gets "far away", or
+// maxIter if it never does, and the last z. The same as escape with
+// mandelbrotFractal and the L2 norm, but faster.
+func mandelbrot(c complex128, maxIter int, radius float64) (int, complex128) {
+  z := c
+  var i int
+  for i = 0; i < maxIter; i++ {
+    z = z*z + c
+    if cmplx.Abs(z) > radius {
+      break
+    }
+  }
+  return i, z
+}
+
+// Like mandelbrot, but conjugating z each iteration
+type tricornFractal struct{}
+
+func (tricornFractal) Init(p complex128) (z, c complex128) {
+  return p, p
+}
+
+func (tricornFractal) Step(z, c complex128) complex128 {
+  z = cmplx.Conj(z)
+  return z*z + c
+}
+
+// Like mandelbrot, but folding z into the first quadrant each iteration
+type burningShipFractal struct{}
+
+func (burningShipFractal) Init(p complex128) (z, c complex128) {
+  return p, p
+}
+
+func (burningShipFractal) Step(z, c complex128) complex128 {
+  z = complex(math.Abs(real(z)), math.Abs(imag(z)))
+  return z*z + c
+}
+
+// z -> z^2 + k from z = p, for a fixed k
+type juliaFractal struct{ k complex128 }
+
+func newJuliaFractal(cfg RenderConfig) Fractal {
+  return juliaFractal{complex(cfg.Julia[0], cfg.Julia[1])}
+}
+
+func (f juliaFractal) Init(p complex128) (z, c complex128) {
+  return p, f.k
+}
+
+func (juliaFractal) Step(z, c complex128) complex128 {
+  return z*z + c
+}
+
+// z -> z^n + c from z = c
+type multibrotFractal struct{ n int }
+
+func newMultibrotFractal(cfg RenderConfig) Fractal {
+  return multibrotFractal{cfg.Degree}
+}
+
+func (multibrotFractal) Init(p complex128) (z, c complex128) {
+  return p, p
+}
+
+func (f multibrotFractal) Step(z, c complex128) complex128 {
+  zn := z
+  for k := 1; k < f.n; k++ {
+    zn *= z
+  }
+  return zn + c
+}
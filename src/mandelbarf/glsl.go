@@ -0,0 +1,155 @@
+package main
+
+import (
+  "fmt"
+  "image/color"
+  "io"
+  "strconv"
+  "strings"
+  "text/template"
+)
+
+// Shadertoy-style fragment shader drawing a view like Render does
+var glslTemplate = template.Must(template.New("glsl").Parse(`// Generated by mandelbarf: {{.Fractal}}, bounds {{.Bounds}}, {{.Iterations}} iterations,
+// {{.Coloring}} coloring with {{.ColorScale}} scale. The palette is interpolated in sRGB.
+// Paste into https://www.shadertoy.com/new, or call mainImage from your own
+// fragment shader with iResolution set.
+
+const vec2 center = vec2({{.CX}}, {{.CY}});
+const vec2 size = vec2({{.Width}}, {{.Height}}); // Of the view in the complex plane
+const mat2 transform = mat2({{.Transform}}); // Applied about the center
+const vec2 offset = vec2({{.Offset}});
+const int maxIter = {{.Iterations}};
+const float escape = {{.Escape}};
+const int stops = {{.Stops}};
+const vec3 palette[stops] = vec3[](
+{{.Palette}});
+
+// Color at t (0 to 1) along the palette
+vec3 gradient(float t) {
+  t = clamp(t, 0.0, 1.0) * float(stops - 1);
+  int i = min(int(t), stops - 2);
+  return mix(palette[i], palette[i + 1], t - float(i));
+}
+
+void mainImage(out vec4 fragColor, in vec2 fragCoord) {
+  // Fit the view to the screen, keeping its aspect ratio
+  float pixel = max(size.x / iResolution.x, size.y / iResolution.y);
+  vec2 c = center + transform * ((fragCoord - iResolution.xy / 2.0) * pixel) + offset;
+
+  vec2 z = c;
+  int i;
+  for (i = 0; i < maxIter; i++) {
+    z = vec2(z.x * z.x - z.y * z.y, {{if .Conjugate}}-{{end}}2.0 * z.x * z.y) + c;
+    if ({{if .Norm}}{{.Norm}} > escape{{else}}dot(z, z) > escape * escape{{end}}) {
+      break;
+    }
+  }
+  if (i == maxIter) {
+    fragColor = {{if .Inside}}{{.Inside}}{{else}}vec4(palette[0], {{if .Alpha}}0.0{{else}}1.0{{end}}){{end}};
+    return;
+  }
+{{if eq .Coloring "smooth"}}
+  float iter = max(0.0, float(i) + 1.0 - log2(log({{or .Norm "length(z)"}}) / log(escape)));
+  float end = float(maxIter);
+{{- else}}
+  float iter = float(i);
+  float end = float(max(maxIter - 1, 255));
+{{- end}}
+{{- if .ColorPeriod}}
+  iter = mod(iter + {{.ColorOffset}}, {{.ColorPeriod}});
+  end = {{.ColorPeriod}};
+{{- else if .ColorOffset}}
+  iter = clamp(iter + {{.ColorOffset}}, 0.0, end);
+{{- end}}
+{{- if eq .ColorScale "log"}}
+  float t = log(1.0 + iter) / log(1.0 + end);
+{{- else if eq .ColorScale "sqrt"}}
+  float t = sqrt(iter / end);
+{{- else}}
+  float t = iter / end;
+{{- end}}
+  fragColor = vec4(gradient(t), 1.0);
+}
+`))
+
+// The GLSL for each norm of z, but l2, which is written out by hand
+var glslNorms = map[string]string{
+  "linf": "max(abs(z.x), abs(z.y))",
+  "l1": "abs(z.x) + abs(z.y)",
+}
+
+// The GLSL vec4 for an inside color, or "" if it isn't set
+func glslInside(c color.RGBA) string {
+  if c.A == 0 {
+    return ""
+  }
+  // Premultiplied, like every color.RGBA
+  a := float64(c.A)
+  return fmt.Sprintf("vec4(%s, %s, %s, %s)", glslFloat(float64(c.R) / a), glslFloat(float64(c.G) / a),
+    glslFloat(float64(c.B) / a), glslFloat(a / 255))
+}
+
+// f as a GLSL float, or "" if it's 0, for the template to leave out
+func glslNonzero(f float64) string {
+  if f == 0 {
+    return ""
+  }
+  return glslFloat(f)
+}
+
+// Write a GLSL shader drawing cfg's view to w
+func writeGLSL(w io.Writer, cfg RenderConfig) error {
+  if cfg.Fractal != "mandelbrot" && cfg.Fractal != "tricorn" {
+    return fmt.Errorf("no GLSL for the %s fractal", cfg.Fractal)
+  }
+  if cfg.Coloring != "iteration" && cfg.Coloring != "smooth" {
+    return fmt.Errorf("no GLSL for %s coloring", cfg.Coloring)
+  }
+  if cfg.InteriorColoring != "" && cfg.InteriorColoring != "end" {
+    return fmt.Errorf("no GLSL for %s interior coloring", cfg.InteriorColoring)
+  }
+  m := cfg.Affine
+  if m == (affine{}) {
+    m = identity
+  }
+  var stops []string
+  for _, c := range cfg.Palette {
+    stops = append(stops, fmt.Sprintf("  vec3(%s, %s, %s)",
+      glslFloat(float64(c.R) / 255), glslFloat(float64(c.G) / 255), glslFloat(float64(c.B) / 255)))
+  }
+  return glslTemplate.Execute(w, map[string]any{
+    "Fractal": cfg.Fractal,
+    "Bounds": fmt.Sprintf("%g,%g,%g,%g", cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax),
+    "Coloring": cfg.Coloring,
+    "ColorScale": cfg.ColorScale,
+    "ColorOffset": glslNonzero(cfg.ColorOffset),
+    "ColorPeriod": glslNonzero(cfg.ColorPeriod),
+    "Conjugate": cfg.Fractal == "tricorn",
+    "Norm": glslNorms[cfg.Norm],
+    "Inside": glslInside(cfg.Inside),
+    "Alpha": cfg.Alpha,
+    "CX": glslFloat((cfg.XMin + cfg.XMax) / 2),
+    "CY": glslFloat((cfg.YMin + cfg.YMax) / 2),
+    "Width": glslFloat(cfg.XMax - cfg.XMin),
+    "Height": glslFloat(cfg.YMax - cfg.YMin),
+    // mat2 takes columns
+    "Transform": strings.Join([]string{
+      glslFloat(m[0]), glslFloat(m[3]), glslFloat(m[1]), glslFloat(m[4]),
+    }, ", "),
+    "Offset": glslFloat(m[2]) + ", " + glslFloat(m[5]),
+    "Iterations": cfg.Iterations,
+    "Escape": glslFloat(cfg.escapeRadius()),
+    "Stops": len(stops),
+    "Palette": strings.Join(stops, ",\n"),
+  })
+}
+
+// Format f as a GLSL float literal, which needs a decimal point
+func glslFloat(f float64) string {
+  s := strconv.FormatFloat(f, 'g', -1, 64)
+  if !strings.ContainsAny(s, ".e") {
+    s += ".0"
+  }
+  return s
+}
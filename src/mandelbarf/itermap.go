@@ -0,0 +1,153 @@
+package main
+
+import (
+  "bufio"
+  "encoding/binary"
+  "fmt"
+  "io"
+  "math"
+  "os"
+)
+
+const pgmMax = 65535 // Largest value in a 16-bit PGM
+
+// Iteration counts of a render, one per output pixel, kept by the workers as
+// they go (see RenderConfig.Escapes) for the maps written alongside the
+// image. A pixel's count is that of its sample nearest the center: the
+// middle one, or right of and below the middle where an even number
+// straddle it. Points that never escape are at the cap. Pixels outside a
+// ROI aren't rendered, so are left at 0.
+type EscapeMap struct {
+  Cols, Rows int
+  Iter []int32 // Iterations to escape, a row at a time from the top
+  // The same smoothed as by -coloring smooth, so in [iter, iter + 1), or
+  // the cap
+  Smooth []float32
+}
+
+func NewEscapeMap(cols, rows int) *EscapeMap {
+  return &EscapeMap{cols, rows, make([]int32, cols * rows), make([]float32, cols * rows)}
+}
+
+// A function keeping the escape of the sample at x,y of the cols x rows
+// image being rendered into, v iterations of at most maxIter ending at z,
+// in cfg.Escapes if it's the one kept for an output pixel, or nil if cfg
+// keeps none. The image is the output's size or, buffered, renderSize.
+func (cfg RenderConfig) escapeKeeper(cols, rows int) func(x, y, v, maxIter int, z complex128) {
+  e := cfg.Escapes
+  if e == nil {
+    return nil
+  }
+  // The output column or row each of the image's is kept for, or -1
+  keptFor := func(n, out int, scale, offset float64) []int {
+    kept := make([]int, n)
+    for i := range kept {
+      kept[i] = -1
+      if n == out {
+        kept[i] = i
+      }
+    }
+    if n != out {
+      for o := 0; o < out; o++ {
+        kept[int(math.Floor(scale * float64(o) + offset + 0.5))] = o
+      }
+    }
+    return kept
+  }
+  m := cfg.outputScale()
+  colOf, rowOf := keptFor(cols, cfg.Cols, m[0], m[2]), keptFor(rows, cfg.Rows, m[4], m[5])
+  logRadius := math.Log(cfg.escapeRadius())
+  norm := cfg.norm()
+  return func(x, y, v, maxIter int, z complex128) {
+    col, row := colOf[x], rowOf[y]
+    if col < 0 || row < 0 {
+      return
+    }
+    i := row * e.Cols + col
+    if v >= maxIter {
+      // A mask's lower cap is still the cap
+      e.Iter[i], e.Smooth[i] = int32(cfg.Iterations), float32(cfg.Iterations)
+      return
+    }
+    // Rounding to float32 mustn't carry it up to the next count
+    e.Iter[i] = int32(v)
+    e.Smooth[i] = min(float32(smoothIter(float64(v), norm(z), logRadius)), math.Nextafter32(float32(v + 1), 0))
+  }
+}
+
+// Write the iteration counts of e, with points that never escape at the cap
+// of iterations, to w as a 16-bit binary PGM (P5). Counts are written as
+// they are if the cap fits in 16 bits; otherwise they're scaled by 65535 /
+// cap, rounding down. Either way the header's maxval is the cap's value,
+// and a comment says which.
+func writeIterMap(w io.Writer, e *EscapeMap, iterations int) error {
+  maxval := min(iterations, pgmMax)
+  counts := make([]byte, 2 * len(e.Iter))
+  for i, v := range e.Iter {
+    binary.BigEndian.PutUint16(counts[2 * i:], uint16(int(v) * maxval / iterations))
+  }
+
+  bw := bufio.NewWriter(w)
+  fmt.Fprintf(bw, "P5\n")
+  if maxval == iterations {
+    fmt.Fprintf(bw, "# iterations to escape; %d means never\n", maxval)
+  } else {
+    fmt.Fprintf(bw, "# iterations to escape, times %d/%d; %d means never\n", pgmMax, iterations, maxval)
+  }
+  fmt.Fprintf(bw, "%d %d\n%d\n", e.Cols, e.Rows, maxval)
+  bw.Write(counts)
+  return bw.Flush()
+}
+
+// Write the iteration map of e to a file
+func saveIterMap(fileName string, e *EscapeMap, iterations int) error {
+  file, err := os.Create(fileName)
+  if err != nil {
+    return &EncodeError{fileName, err}
+  }
+  defer file.Close()
+  if err := writeIterMap(file, e, iterations); err != nil {
+    return &EncodeError{fileName, err}
+  }
+  if err := file.Close(); err != nil {
+    return &EncodeError{fileName, err}
+  }
+  return nil
+}
+
+// Write the smoothed escape values of e to w, unquantized, for analysis.
+// The layout, all little-endian:
+//
+//   uint32  columns
+//   uint32  rows
+//   float32 values, columns of them per row, rows from the top
+//
+// An escaped point's value is its iterations before |z| passed the escape
+// radius, smoothed as by -coloring smooth, so it's in [iter, iter + 1) and
+// always below the cap. Points that never escape are exactly the cap.
+func writeFloatMap(w io.Writer, e *EscapeMap) error {
+  data := make([]byte, 8 + 4 * len(e.Smooth))
+  binary.LittleEndian.PutUint32(data, uint32(e.Cols))
+  binary.LittleEndian.PutUint32(data[4:], uint32(e.Rows))
+  for i, smooth := range e.Smooth {
+    binary.LittleEndian.PutUint32(data[8 + 4 * i:], math.Float32bits(smooth))
+  }
+  _, err := w.Write(data)
+  return err
+}
+
+// Write the float map of e to a file
+func saveFloatMap(fileName string, e *EscapeMap) error {
+  file, err := os.Create(fileName)
+  if err != nil {
+    return &EncodeError{fileName, err}
+  }
+  defer file.Close()
+  if err := writeFloatMap(file, e); err != nil {
+    return &EncodeError{fileName, err}
+  }
+  if err := file.Close(); err != nil {
+    return &EncodeError{fileName, err}
+  }
+  return nil
+}
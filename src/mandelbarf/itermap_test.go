@@ -0,0 +1,47 @@
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "fmt"
+  "testing"
+)
+
+// Counts past 255 make it from the kernel through the render to the map
+// unwrapped, for points that escape as well as the cap
+func TestIterMapPast255(t *testing.T) {
+  cfg := testConfig(t, "-iterations", "1000", "-bounds", "-0.75,-0.74,0.1,0.11")
+  cfg.Escapes = NewEscapeMap(cfg.Cols, cfg.Rows)
+  testRender(t, cfg)
+  most := int32(0)
+  for _, v := range cfg.Escapes.Iter {
+    if v < 1000 {
+      most = max(most, v)
+    }
+  }
+  if most <= 255 {
+    t.Fatalf("most iterations of an escaping pixel is %d, want over 255", most)
+  }
+
+  var b bytes.Buffer
+  if err := writeIterMap(&b, cfg.Escapes, cfg.Iterations); err != nil {
+    t.Fatal(err)
+  }
+  var cols, rows, maxval int
+  header := "P5\n# iterations to escape; 1000 means never\n"
+  if !bytes.HasPrefix(b.Bytes(), []byte(header)) {
+    t.Fatalf("map starts %q, want %q", b.Bytes()[:len(header)], header)
+  }
+  if _, err := fmt.Fscanf(bytes.NewReader(b.Bytes()[len(header):]), "%d %d\n%d\n", &cols, &rows, &maxval); err != nil {
+    t.Fatal(err)
+  }
+  if cols != cfg.Cols || rows != cfg.Rows || maxval != 1000 {
+    t.Errorf("map is %dx%d to %d, want %dx%d to 1000", cols, rows, maxval, cfg.Cols, cfg.Rows)
+  }
+  counts := b.Bytes()[b.Len() - 2 * cols * rows:]
+  for i, v := range cfg.Escapes.Iter {
+    if got := binary.BigEndian.Uint16(counts[2 * i:]); int32(got) != v {
+      t.Fatalf("pixel %d written as %d, want %d", i, got, v)
+    }
+  }
+}
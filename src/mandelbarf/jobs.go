@@ -0,0 +1,121 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io"
+  "log/slog"
+  "os"
+  "slices"
+  "time"
+)
+
+const maxJobLine = 16 << 20 // Longest line of JSON a job can be
+
+// A line of input to the jobs command: the PNG file to write, and the
+// RenderConfig fields to change from the one the flags built, in the JSON
+// embedded in rendered PNGs
+type job struct {
+  Out string `json:"out"`
+  Config json.RawMessage `json:"config"`
+}
+
+// What the jobs command reports of each job, as a line of JSON on stdout
+type jobResult struct {
+  Line int `json:"line"`
+  Out string `json:"out,omitempty"`
+  Bytes int64 `json:"bytes,omitempty"`
+  Elapsed float64 `json:"elapsed,omitempty"` // Seconds
+  Error string `json:"error,omitempty"`
+}
+
+// Render jobs read from stdin, one JSON job per line, as they arrive, until
+// stdin closes. Each starts from the config the render flags build, so the
+// flags set defaults for every job. A job moving the view gets an iteration
+// cap picked for it, unless -iterations or the job sets one. A line that
+// can't be parsed or rendered is logged and reported, and the next one is
+// read.
+func jobsCmd(args []string) error {
+  fs := flag.NewFlagSet("jobs", flag.ExitOnError)
+  config := renderFlags(fs)
+  verbosity := logFlags(fs)
+  colorTag := fs.String("color-tag", "srgb", "tag the PNGs as srgb, as full (sRGB with gAMA and cHRM fallbacks), or none")
+  fs.Parse(args)
+  verbosity()
+  base, err := config()
+  if err != nil {
+    return err
+  }
+  opts, err := parsePNGOptions(*colorTag, false, "default")
+  if err != nil {
+    return err
+  }
+  return runJobs(os.Stdin, os.Stdout, base, !flagSet(fs, "iterations"), opts)
+}
+
+// The loop of jobsCmd, reading jobs from r and reporting them to w
+func runJobs(r io.Reader, w io.Writer, base RenderConfig, autoIter bool, opts pngOptions) error {
+  scanner := bufio.NewScanner(r)
+  scanner.Buffer(make([]byte, 64 << 10), maxJobLine)
+  enc := json.NewEncoder(w)
+  for line := 1; scanner.Scan(); line++ {
+    if len(scanner.Bytes()) == 0 {
+      continue
+    }
+    result := runJob(scanner.Bytes(), base, autoIter, opts)
+    result.Line = line
+    if result.Error != "" {
+      slog.Error("job failed", "line", line, "err", result.Error)
+    }
+    if err := enc.Encode(result); err != nil {
+      return err
+    }
+  }
+  return scanner.Err()
+}
+
+// Render one line of JSON as a job. If autoIter is set, a job moving base's
+// bounds without setting Iterations gets a cap picked for its view.
+func runJob(text []byte, base RenderConfig, autoIter bool, opts pngOptions) jobResult {
+  var j job
+  if err := json.Unmarshal(text, &j); err != nil {
+    return jobResult{Error: fmt.Sprintf("bad job: %v", err)}
+  }
+  result := jobResult{Out: j.Out}
+  if j.Out == "" {
+    result.Error = "job has no out file"
+    return result
+  }
+  // Unmarshaling into a slice reuses its array, which is base's
+  cfg := base
+  cfg.Palette, cfg.InteriorPalette = slices.Clone(base.Palette), slices.Clone(base.InteriorPalette)
+  if len(j.Config) > 0 {
+    if err := json.Unmarshal(j.Config, &cfg); err != nil {
+      result.Error = fmt.Sprintf("bad config: %v", err)
+      return result
+    }
+  }
+  // Matched as the config's fields are, so any case of the name counts
+  var set struct{ Iterations *int }
+  json.Unmarshal(j.Config, &set)
+  moved := [4]float64{cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax} != [4]float64{base.XMin, base.XMax, base.YMin, base.YMax}
+  if autoIter && moved && set.Iterations == nil {
+    cfg.Iterations = cfg.autoIterations()
+  }
+  start := time.Now()
+  m, err := Render(cfg)
+  if err != nil {
+    result.Error = err.Error()
+    return result
+  }
+  opts.config = &cfg
+  result.Bytes, err = writePNG(j.Out, m, opts)
+  if err != nil {
+    result.Error = err.Error()
+    return result
+  }
+  result.Elapsed = time.Since(start).Seconds()
+  return result
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+  "bufio"
+  "image"
+  "image/color"
+  "io"
+  "math"
+  "math/bits"
+)
+
+// Go's image/jpeg only writes baseline JPEGs, which load top to bottom. This
+// writes progressive ones, which show the whole image blurry first and then
+// sharpen, by spectral selection: the first scan has every block's DC
+// coefficient, and the later ones bands of AC coefficients. Without
+// successive approximation, every scan can use the spec's typical Huffman
+// tables. Color is full-resolution YCbCr, with no chroma subsampling.
+
+// The scans, by component (0 luma, 1 and 2 chroma, or -1 for all of them,
+// interleaved) and the band of zigzag coefficients from start to end
+var progressiveScans = []struct{ comp, start, end int }{
+  {-1, 0, 0},
+  {0, 1, 5},
+  {1, 1, 63},
+  {2, 1, 63},
+  {0, 6, 63},
+}
+
+// Natural order index of each zigzag coefficient
+var unzig = [64]int{
+  0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+  12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+  35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+  58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type jpegHuffmanSpec struct {
+  counts [16]byte
+  symbols []byte
+}
+
+// Code and length in bits of each symbol
+type huffmanCodes [256]struct {
+  code uint32
+  length int
+}
+
+// Assign codes to spec's symbols in order, as the spec's annex C does
+func (spec jpegHuffmanSpec) codes() huffmanCodes {
+  var h huffmanCodes
+  code, k := uint32(0), 0
+  for length := 1; length <= 16; length++ {
+    for i := 0; i < int(spec.counts[length - 1]); i++ {
+      h[spec.symbols[k]].code, h[spec.symbols[k]].length = code, length
+      code++
+      k++
+    }
+    code <<= 1
+  }
+  return h
+}
+
+// Writes entropy-coded data, stuffing a zero after each 0xff byte
+type jpegBits struct {
+  w *bufio.Writer
+  acc uint32
+  n int // Bits in acc
+}
+
+func (b *jpegBits) write(v uint32, n int) {
+  b.acc = b.acc << n | v & (1 << n - 1)
+  b.n += n
+  for b.n >= 8 {
+    c := byte(b.acc >> (b.n - 8))
+    b.w.WriteByte(c)
+    if c == 0xff {
+      b.w.WriteByte(0)
+    }
+    b.n -= 8
+  }
+}
+
+// Pad the last byte of a scan with 1s
+func (b *jpegBits) flush() {
+  if b.n > 0 {
+    b.write(1 << (8 - b.n) - 1, 8 - b.n)
+  }
+  b.acc = 0
+}
+
+// Write a coefficient's size category with h, then its bits
+func (b *jpegBits) emit(h *huffmanCodes, run int, v int32) {
+  a := v
+  if a < 0 {
+    a = -a
+    v--
+  }
+  size := bits.Len32(uint32(a))
+  c := h[run << 4 | size]
+  b.write(c.code, c.length)
+  b.write(uint32(v), size)
+}
+
+// Quantization table t for quality 1 to 100, as libjpeg scales it
+func scaledQuant(t, quality int) [64]int32 {
+  scale := 200 - 2 * quality
+  if quality < 50 {
+    scale = 5000 / quality
+  }
+  var q [64]int32
+  for k, v := range jpegQuant[t] {
+    q[k] = min(max((int32(v) * int32(scale) + 50) / 100, 1), 255)
+  }
+  return q
+}
+
+// cos((2x+1)uπ/16), times C(u)/2 where C(0) is 1/√2 and otherwise 1
+var dctCos = func() (c [8][8]float64) {
+  for u := range c {
+    for x := range c[u] {
+      c[u][x] = math.Cos(float64(2 * x + 1) * float64(u) * math.Pi / 16) / 2
+      if u == 0 {
+        c[u][x] /= math.Sqrt2
+      }
+    }
+  }
+  return c
+}()
+
+// Forward DCT of an 8x8 block in natural order, in place
+func fdct(block *[64]float64) {
+  var tmp [64]float64
+  for y := 0; y < 8; y++ {
+    for u := 0; u < 8; u++ {
+      s := 0.0
+      for x := 0; x < 8; x++ {
+        s += dctCos[u][x] * block[y * 8 + x]
+      }
+      tmp[y * 8 + u] = s
+    }
+  }
+  for u := 0; u < 8; u++ {
+    for v := 0; v < 8; v++ {
+      s := 0.0
+      for y := 0; y < 8; y++ {
+        s += dctCos[v][y] * tmp[y * 8 + u]
+      }
+      block[v * 8 + u] = s
+    }
+  }
+}
+
+// Encode m to w as a progressive JPEG of quality 1 to 100. JPEG has no
+// alpha, so m should be opaque.
+func encodeProgressiveJPEG(w io.Writer, m image.Image, quality int) error {
+  quality = min(max(quality, 1), 100)
+  b := m.Bounds()
+  bx, by := (b.Dx() + 7) / 8, (b.Dy() + 7) / 8
+  quant := [2][64]int32{scaledQuant(0, quality), scaledQuant(1, quality)}
+
+  // Quantized coefficients of each component's blocks, in zigzag order,
+  // with the image's edge pixels repeated out to whole blocks
+  coefs := [3][][64]int32{}
+  for c := range coefs {
+    coefs[c] = make([][64]int32, bx * by)
+  }
+  var planes [3][64]float64
+  for i := 0; i < bx * by; i++ {
+    for y := 0; y < 8; y++ {
+      for x := 0; x < 8; x++ {
+        px := b.Min.X + min(i % bx * 8 + x, b.Dx() - 1)
+        py := b.Min.Y + min(i / bx * 8 + y, b.Dy() - 1)
+        rgb := color.RGBAModel.Convert(m.At(px, py)).(color.RGBA)
+        yy, cb, cr := color.RGBToYCbCr(rgb.R, rgb.G, rgb.B)
+        for c, v := range []uint8{yy, cb, cr} {
+          planes[c][y * 8 + x] = float64(v) - 128
+        }
+      }
+    }
+    for c := range planes {
+      fdct(&planes[c])
+      q := &quant[min(c, 1)]
+      for k := range coefs[c][i] {
+        coefs[c][i][k] = int32(math.Round(planes[c][unzig[k]] / float64(q[k])))
+      }
+    }
+  }
+
+  bw := bufio.NewWriter(w)
+  bw.Write([]byte{0xff, 0xd8}) // SOI
+  segment := func(marker byte, data []byte) {
+    bw.Write([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+    bw.Write(data)
+  }
+  segment(0xe0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+  for t := range quant {
+    data := []byte{byte(t)}
+    for _, q := range quant[t] {
+      data = append(data, byte(q))
+    }
+    segment(0xdb, data) // DQT
+  }
+  segment(0xc2, []byte{8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), 3,
+    1, 0x11, 0, 2, 0x11, 1, 3, 0x11, 1}) // SOF2: progressive, 3 components at 1x1
+  var codes [2][2]huffmanCodes
+  for class := range jpegHuffman {
+    for t, spec := range jpegHuffman[class] {
+      segment(0xc4, append(append([]byte{byte(class << 4 | t)}, spec.counts[:]...), spec.symbols...)) // DHT
+      codes[class][t] = spec.codes()
+    }
+  }
+
+  out := jpegBits{w: bw}
+  for _, scan := range progressiveScans {
+    comps := []int{scan.comp}
+    if scan.comp < 0 {
+      comps = []int{0, 1, 2}
+    }
+    sos := []byte{byte(len(comps))}
+    for _, c := range comps {
+      // The DC table for DC scans, or the AC for AC ones
+      t := byte(min(c, 1))
+      if scan.start == 0 {
+        t <<= 4
+      }
+      sos = append(sos, byte(c + 1), t)
+    }
+    segment(0xda, append(sos, byte(scan.start), byte(scan.end), 0))
+    if scan.start == 0 {
+      var pred [3]int32
+      for i := 0; i < bx * by; i++ {
+        for _, c := range comps {
+          dc := coefs[c][i][0]
+          out.emit(&codes[0][min(c, 1)], 0, dc - pred[c])
+          pred[c] = dc
+        }
+      }
+    } else {
+      h := &codes[1][min(scan.comp, 1)]
+      for i := 0; i < bx * by; i++ {
+        run := 0
+        for k := scan.start; k <= scan.end; k++ {
+          v := coefs[scan.comp][i][k]
+          if v == 0 {
+            run++
+            continue
+          }
+          for ; run >= 16; run -= 16 {
+            out.emit(h, 15, 0) // ZRL
+          }
+          out.emit(h, run, v)
+          run = 0
+        }
+        if run > 0 {
+          out.emit(h, 0, 0) // EOB
+        }
+      }
+    }
+    out.flush()
+  }
+  bw.Write([]byte{0xff, 0xd9}) // EOI
+  return bw.Flush()
+}
+
+// The example quantization tables of the JPEG spec, annex K, for luma and
+// chroma, in zigzag order
+var jpegQuant = [2][64]byte{
+  {
+    16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+    26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+    56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+    95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+  },
+  {
+    17, 18, 18, 24, 21, 24, 47, 26, 26, 47, 99, 66, 56, 66, 99, 99,
+    99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+    99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+    99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+  },
+}
+
+// The typical Huffman tables of the JPEG spec, annex K: the number of codes
+// of each length from 1 to 16 bits, and the symbols they code in order.
+// Indexed by class (DC or AC) and then table (luma or chroma).
+var jpegHuffman = [2][2]jpegHuffmanSpec{
+  {
+    { // Luma DC
+      [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+      []byte{
+        0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b,
+      },
+    },
+    { // Chroma DC
+      [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+      []byte{
+        0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b,
+      },
+    },
+  },
+  {
+    { // Luma AC
+      [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+      []byte{
+        0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06,
+        0x13, 0x51, 0x61, 0x07, 0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+        0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0, 0x24, 0x33, 0x62, 0x72,
+        0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+        0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45,
+        0x46, 0x47, 0x48, 0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+        0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x73, 0x74, 0x75,
+        0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+        0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3,
+        0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+        0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9,
+        0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+        0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4,
+        0xf5, 0xf6, 0xf7, 0xf8, 0xf9, 0xfa,
+      },
+    },
+    { // Chroma AC
+      [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+      []byte{
+        0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41,
+        0x51, 0x07, 0x61, 0x71, 0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+        0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0, 0x15, 0x62, 0x72, 0xd1,
+        0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+        0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44,
+        0x45, 0x46, 0x47, 0x48, 0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+        0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x73, 0x74,
+        0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+        0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a,
+        0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+        0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7,
+        0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+        0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4,
+        0xf5, 0xf6, 0xf7, 0xf8, 0xf9, 0xfa,
+      },
+    },
+  },
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+  "container/list"
+  "sync"
+)
+
+// Identifies a tile by what it draws, so tiles asked for differently but
+// drawn the same share an entry. The rest of the config is the server's,
+// the same for every tile.
+type tileKey struct {
+  bounds [4]uint64 // Bits of xMin, xMax, yMin, yMax, snapped by tileConfig
+  cols, rows, iterations int
+  fractal string
+  palette string // The palette's colors, 4 bytes each
+}
+
+// Least-recently-used cache of encoded tiles, holding up to limit bytes. Safe
+// for concurrent use.
+type lruCache struct {
+  mu sync.Mutex
+  limit, size int
+  order *list.List // Of *lruEntry, most recently used first
+  entries map[tileKey]*list.Element
+}
+
+type lruEntry struct {
+  key tileKey
+  data []byte
+}
+
+func newLRUCache(limit int) *lruCache {
+  return &lruCache{limit: limit, order: list.New(), entries: map[tileKey]*list.Element{}}
+}
+
+func (c *lruCache) get(key tileKey) ([]byte, bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  e := c.entries[key]
+  if e == nil {
+    return nil, false
+  }
+  c.order.MoveToFront(e)
+  return e.Value.(*lruEntry).data, true
+}
+
+// Add data under key, evicting the least recently used entries to make room
+func (c *lruCache) put(key tileKey, data []byte) {
+  if len(data) > c.limit {
+    return
+  }
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if e := c.entries[key]; e != nil {
+    c.size -= len(e.Value.(*lruEntry).data)
+    c.order.Remove(e)
+  }
+  c.entries[key] = c.order.PushFront(&lruEntry{key, data})
+  c.size += len(data)
+  for c.size > c.limit {
+    oldest := c.order.Remove(c.order.Back()).(*lruEntry)
+    delete(c.entries, oldest.key)
+    c.size -= len(oldest.data)
+  }
+}
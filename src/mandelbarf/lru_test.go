@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+// Entries are evicted least recently used first, once their bytes pass the
+// limit, and an entry bigger than the whole cache isn't kept
+func TestLRUEvictsByBytes(t *testing.T) {
+  c := newLRUCache(10)
+  key := func(name string) tileKey {
+    return tileKey{fractal: name}
+  }
+  c.put(key("a"), make([]byte, 4))
+  c.put(key("b"), make([]byte, 4))
+  c.get(key("a"))
+  c.put(key("c"), make([]byte, 4)) // 12 bytes, so b goes
+  c.put(key("d"), make([]byte, 11))
+  for name, want := range map[string]bool{"a": true, "b": false, "c": true, "d": false} {
+    if _, ok := c.get(key(name)); ok != want {
+      t.Errorf("%s cached: got %v, want %v", name, ok, want)
+    }
+  }
+  if c.size != 8 {
+    t.Errorf("size %d, want 8", c.size)
+  }
+
+  // Replacing an entry counts only its new bytes
+  c.put(key("a"), make([]byte, 6))
+  if _, ok := c.get(key("c")); !ok || c.size != 10 {
+    t.Errorf("after replacing a: c cached %v, size %d; want true, 10", ok, c.size)
+  }
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
-  "bufio"
+  "context"
+  "errors"
   "fmt"
   "image"
   "image/color"
-  "image/png"
+  "log/slog"
+  "math"
   "math/cmplx"
-  "os"
+  "math/rand/v2"
+  "runtime"
+  "slices"
+  "sync"
+  "time"
 )
 
 /*
@@ -22,19 +28,23 @@ that point is in the mandelbrot set
 
 // Settings
 
+// Default bounds
 const xMin = -2.0
 const xMax =  1.0
 const yMin = -1.0
 const yMax =  1.0
 
 const outFileName = "out.png"
+const thumbFileName = "thumb.png" // For -thumbnail
 const imgCols = 6144
 const imgRows = 4096
-const scale = 6 // Supersample by this much in both dimensions
-const escapeThresh = 100.0 // Treat a point as escaping if it exceeds this
+const scale = 6 // Supersample by this much in both dimensions (default)
+const escapeThresh = 100.0 // Treat a point as escaping if it exceeds this (default)
 
-const workerNum = 6
-const chunkNum = 100 // Divide the image into this many chunks
+const workerNum = 6 // Default
+const chunksPerWorker = 8 // By default, divide the image into this many chunks per worker
+const precisionWarn = 16 // Warn when pixels are fewer float64s apart than this
+const interiorFalloff = 32 // Output pixels in from the boundary distance interior coloring shades over
 
 // Image implementation
 
@@ -54,18 +64,21 @@ func (i img) Bounds() image.Rectangle {
   }
 }
 
+// Transparent outside the bounds, like image.RGBA
 func (i img) At(x, y int) color.Color {
   if 0 <= x && x < i.cols && 0 <= y && y < i.rows {
     return i.px[y * i.cols + x]
   }
-  return color.RGBA{255, 0, 0, 255}
+  return color.RGBA{}
 }
 
+// Pixels outside the bounds are never meant to be read, so reading one is a
+// bug rather than something to paper over with a color
 func (i img) get(x, y int) color.RGBA {
-  if 0 <= x && x < i.cols && 0 <= y && y < i.rows {
-    return i.px[y * i.cols + x]
+  if !(0 <= x && x < i.cols && 0 <= y && y < i.rows) {
+    panic(fmt.Sprintf("get(%d, %d) outside %dx%d image", x, y, i.cols, i.rows))
   }
-  return color.RGBA{255, 0, 0, 255}
+  return i.px[y * i.cols + x]
 }
 
 func (i img) set(x, y int, c color.RGBA) {
@@ -74,6 +87,11 @@ func (i img) set(x, y int, c color.RGBA) {
   }
 }
 
+// For draw.Image, so overlays can draw with the standard library
+func (i img) Set(x, y int, c color.Color) {
+  i.set(x, y, color.RGBAModel.Convert(c).(color.RGBA))
+}
+
 // img utilities
 
 func mkImg(cols, rows int) img {
@@ -84,122 +102,1033 @@ func mkImg(cols, rows int) img {
   }
 }
 
-func downScale(in img, scale int) img {
-  out := mkImg(in.cols / scale, in.rows / scale)
-  samples := scale * scale
-  for outRow := 0; outRow < out.rows; outRow++ {
-    for outCol := 0; outCol < out.cols; outCol++ {
-      outRed, outGreen, outBlue := 0, 0, 0
-      for subRow := 0; subRow < scale; subRow++ {
-        for subCol := 0; subCol < scale; subCol++ {
-          inRow := outRow * scale + subRow
-          inCol := outCol * scale + subCol
-          inColor := in.get(inCol, inRow)
-          outRed += int(inColor.R)
-          outGreen += int(inColor.G)
-          outBlue += int(inColor.B)
+// Pixel buffers of finished renders, for the next render to reuse. The
+// server and TUI render view after view of the same size, and reusing
+// buffers spares the garbage collector.
+var imgPool sync.Pool
+
+// Like mkImg, but reusing a buffer from imgPool if one is big enough
+func mkPooledImg(cols, rows int) img {
+  if px, ok := imgPool.Get().([]color.RGBA); ok && cap(px) >= cols * rows {
+    px = px[:cols * rows]
+    clear(px)
+    return img{cols, rows, px}
+  }
+  return mkImg(cols, rows)
+}
+
+// Return i's buffer to imgPool. i mustn't be used after.
+func freeImg(i img) {
+  imgPool.Put(i.px[:0])
+}
+
+// Downsamples a supersampled image one output row at a time, in order
+type rowScaler interface {
+  // How many rows of the input must be done before output row outRow
+  need(outRow int) int
+  // Fill out with output row outRow
+  row(outRow int, out []color.RGBA)
+}
+
+// The rowScaler for cfg's filter, reading in
+func (cfg RenderConfig) rowScaler(in img) (rowScaler, error) {
+  if cfg.Filter == "lanczos" || cfg.Supersample > 0 {
+    return newLanczosScaler(in, cfg.Cols, cfg.Rows, cfg.LanczosA, cfg.LinearLight, cfg.WrapX), nil
+  }
+  if in.cols % cfg.ScaleX != 0 || in.rows % cfg.ScaleY != 0 {
+    return nil, fmt.Errorf("%dx%d image not divisible by scale %dx%d",
+      in.cols, in.rows, cfg.ScaleX, cfg.ScaleY)
+  }
+  return boxScaler{in, cfg.ScaleX, cfg.ScaleY, 0, 0, cfg.LinearLight}, nil
+}
+
+// Box-average m down by scaleX horizontally and scaleY vertically, as renders
+// are, for use on any image. The result's bounds start at 0,0.
+func DownScale(m image.Image, scaleX, scaleY int) (*image.RGBA, error) {
+  b := m.Bounds()
+  if scaleX < 1 || scaleY < 1 {
+    return nil, fmt.Errorf("scale must be at least 1, got %dx%d", scaleX, scaleY)
+  }
+  if b.Dx() % scaleX != 0 || b.Dy() % scaleY != 0 {
+    return nil, fmt.Errorf("%dx%d image not divisible by scale %dx%d",
+      b.Dx(), b.Dy(), scaleX, scaleY)
+  }
+  in := toImg(m)
+  return scaleImage(boxScaler{in, scaleX, scaleY, 0, 0, false}, in.cols / scaleX, in.rows / scaleY), nil
+}
+
+// Like DownScale, but with the boxes on a grid with a corner at origin, in
+// m's coordinates, rather than at m's top left. A crop of a supersampled
+// image, taken with SubImage and given the image's own origin, comes out as
+// exactly the output pixels it covers, so crops side by side join without
+// a seam. Boxes cut off by m's edges are left out or, with partial, averaged
+// over the part of them in m. The result holds the boxes from the top left
+// one kept, with its bounds starting at 0,0.
+func DownScaleFrom(m image.Image, scaleX, scaleY int, origin image.Point, partial bool) (*image.RGBA, error) {
+  b := m.Bounds()
+  if scaleX < 1 || scaleY < 1 {
+    return nil, fmt.Errorf("scale must be at least 1, got %dx%d", scaleX, scaleY)
+  }
+  // Where m's first pixel is in its box, and so how far the first box to
+  // use starts before it; negative skips into m to a whole box
+  shift := func(min, origin, size int) int {
+    inBox := ((min - origin) % size + size) % size
+    if partial {
+      return inBox
+    }
+    return -((size - inBox) % size)
+  }
+  shiftX, shiftY := shift(b.Min.X, origin.X, scaleX), shift(b.Min.Y, origin.Y, scaleY)
+  cols, rows := (b.Dx() + shiftX) / scaleX, (b.Dy() + shiftY) / scaleY
+  if partial {
+    cols, rows = (b.Dx() + shiftX + scaleX - 1) / scaleX, (b.Dy() + shiftY + scaleY - 1) / scaleY
+  }
+  if cols < 1 || rows < 1 {
+    return nil, fmt.Errorf("%dx%d image holds no whole %dx%d boxes", b.Dx(), b.Dy(), scaleX, scaleY)
+  }
+  in := toImg(m)
+  return scaleImage(boxScaler{in, scaleX, scaleY, shiftX, shiftY, false}, cols, rows), nil
+}
+
+// Resample m down to cols x rows with a lanczos filter, for thumbnails and
+// other sizes that aren't a whole factor smaller. The result's bounds start
+// at 0,0.
+func Resize(m image.Image, cols, rows int) (*image.RGBA, error) {
+  b := m.Bounds()
+  if cols < 1 || rows < 1 || cols > b.Dx() || rows > b.Dy() {
+    return nil, fmt.Errorf("can't resize %dx%d image to %dx%d", b.Dx(), b.Dy(), cols, rows)
+  }
+  in := toImg(m)
+  return scaleImage(newLanczosScaler(in, cols, rows, lanczosA, false, false), cols, rows), nil
+}
+
+// A copy of m as an img
+func toImg(m image.Image) img {
+  b := m.Bounds()
+  i := mkImg(b.Dx(), b.Dy())
+  for y := 0; y < i.rows; y++ {
+    for x := 0; x < i.cols; x++ {
+      i.px[y * i.cols + x] = color.RGBAModel.Convert(m.At(b.Min.X + x, b.Min.Y + y)).(color.RGBA)
+    }
+  }
+  return i
+}
+
+// The cols x rows image s scales its input to
+func scaleImage(s rowScaler, cols, rows int) *image.RGBA {
+  out := image.NewRGBA(image.Rect(0, 0, cols, rows))
+  row := make([]color.RGBA, cols)
+  for y := 0; y < rows; y++ {
+    s.row(y, row)
+    for x, c := range row {
+      out.SetRGBA(x, y, c)
+    }
+  }
+  return out
+}
+
+// Copies rows of an image rendered at output size
+type copyScaler struct {
+  in img
+}
+
+func (s copyScaler) need(outRow int) int {
+  return outRow + 1
+}
+
+func (s copyScaler) row(outRow int, out []color.RGBA) {
+  copy(out, s.in.px[outRow * s.in.cols:])
+}
+
+// Box-averages scaleX by scaleY blocks of in. All four channels are
+// averaged; color.RGBA is alpha-premultiplied, so this weights each sample's
+// color by its coverage. The first box starts shiftX columns and shiftY rows
+// before in's top left (or after, if negative), and boxes cut off by in's
+// edges average what's left of them.
+type boxScaler struct {
+  in img
+  scaleX, scaleY int
+  shiftX, shiftY int
+  linear bool // Average in linear light
+}
+
+func (s boxScaler) need(outRow int) int {
+  return min(s.in.rows, (outRow + 1) * s.scaleY - s.shiftY)
+}
+
+// The columns or rows of in, from lo to hi, box i of size covers
+func boxSpan(i, size, shift, limit int) (lo, hi int) {
+  return max(0, i * size - shift), min(limit, (i + 1) * size - shift)
+}
+
+func (s boxScaler) row(outRow int, out []color.RGBA) {
+  rowLo, rowHi := boxSpan(outRow, s.scaleY, s.shiftY, s.in.rows)
+  if s.linear {
+    for outCol := range out {
+      colLo, colHi := boxSpan(outCol, s.scaleX, s.shiftX, s.in.cols)
+      samples := (colHi - colLo) * (rowHi - rowLo)
+      var sum [4]float64
+      for inRow := rowLo; inRow < rowHi; inRow++ {
+        for inCol := colLo; inCol < colHi; inCol++ {
+          c := channels(s.in.get(inCol, inRow), true)
+          for k := range sum {
+            sum[k] += c[k] / float64(samples)
+          }
         }
       }
-      outRed /= samples
-      outGreen /= samples
-      outBlue /= samples
-      outColor := color.RGBA{uint8(outRed), uint8(outGreen), uint8(outBlue), 255}
-      out.set(outCol, outRow, outColor)
+      out[outCol] = fromChannels(sum, true)
     }
+    return
+  }
+  for outCol := range out {
+    colLo, colHi := boxSpan(outCol, s.scaleX, s.shiftX, s.in.cols)
+    samples := (colHi - colLo) * (rowHi - rowLo)
+    outRed, outGreen, outBlue, outAlpha := 0, 0, 0, 0
+    for inRow := rowLo; inRow < rowHi; inRow++ {
+      for inCol := colLo; inCol < colHi; inCol++ {
+        inColor := s.in.get(inCol, inRow)
+        outRed += int(inColor.R)
+        outGreen += int(inColor.G)
+        outBlue += int(inColor.B)
+        outAlpha += int(inColor.A)
+      }
+    }
+    outRed /= samples
+    outGreen /= samples
+    outBlue /= samples
+    outAlpha /= samples
+    out[outCol] = color.RGBA{uint8(outRed), uint8(outGreen), uint8(outBlue), uint8(outAlpha)}
   }
-  return out
 }
 
 // Math!
 
-// Map x from the range x1,y1 to x2,y2
-func linear(x, x1, x2, y1, y2 float64) float64 {
-  slope := (y2 - y1) / (x2 - x1)
-  intercept := y1 - x1 * slope
-  return x * slope + intercept
+// A 2x3 affine transform, mapping x,y to a*x + b*y + c, d*x + e*y + f
+type affine [6]float64
+
+var identity = affine{1, 0, 0, 0, 1, 0}
+
+func (m affine) apply(x, y float64) (float64, float64) {
+  return m[0] * x + m[1] * y + m[2], m[3] * x + m[4] * y + m[5]
 }
 
-// Return the number of iterations (max 255) before the point gets "far away"
-func mandelbrot(c complex128) int {
-  z := c
-  var i int
-  for i = 0; i < 256; i++ {
-    z = z*z + c
-    if cmplx.Abs(z) > escapeThresh {
-      break
-    }
+// The transform doing n, then m
+func (m affine) compose(n affine) affine {
+  return affine{
+    m[0] * n[0] + m[1] * n[3], m[0] * n[1] + m[1] * n[4], m[0] * n[2] + m[1] * n[5] + m[2],
+    m[3] * n[0] + m[4] * n[3], m[3] * n[1] + m[4] * n[4], m[3] * n[2] + m[4] * n[5] + m[5],
   }
-  return i
 }
 
-type rowRange struct {
+// The inverse transform, assuming there is one
+func (m affine) invert() affine {
+  det := m[0] * m[4] - m[1] * m[3]
+  a, b, d, e := m[4] / det, -m[1] / det, -m[3] / det, m[0] / det
+  return affine{a, b, -(a * m[2] + b * m[5]), d, e, -(d * m[2] + e * m[5])}
+}
+
+// Random numbers for the stream'th unit of randomized work, from seed.
+// Anything random must divide its work into units that don't depend on the
+// worker count and draw each unit from its own stream; then the same seed
+// gives the same image with any number of workers.
+func sampleRNG(seed uint64, stream int) *rand.Rand {
+  return rand.New(rand.NewPCG(seed, uint64(stream)))
+}
+
+// Iterate the mandelbrot set's z -> z^2 + c from z = c, once, returning what
+// the colorings need: the iterations before |z| exceeded radius, the same
+// count smoothed so it varies continuously with c, and whether c escaped at
+// all. A point whose |z| lands exactly on radius hasn't escaped yet. If c
+// never escapes within maxIter iterations, iter is maxIter and smooth is
+// too. Otherwise neither depends on maxIter, so raising the cap leaves
+// points that had escaped where they were. radius should be at least 2, past
+// which escape is certain.
+func EscapeTime(c complex128, maxIter int, radius float64) (iter int, smooth float64, escaped bool) {
+  iter, z := mandelbrot(c, maxIter, radius)
+  if iter == maxIter {
+    return iter, float64(iter), false
+  }
+  return iter, smoothIter(float64(iter), cmplx.Abs(z), math.Log(radius)), true
+}
+
+// A chunk of work: the pixels in rows startRow to stopRow and columns
+// startCol to stopCol (exclusive)
+type workRect struct {
   startRow, stopRow int
+  startCol, stopCol int
+}
+
+// A function giving the color at x,y in a cols x rows grid of pixels over
+// the view, after mapping x,y by pre, iterating at most maxIter times, and
+// the kernel's iterations and final z there (0 for a fractal without one)
+func (cfg RenderConfig) pointColorer(colorer Colorer, cols, rows int, pre affine) func(x, y float64, maxIter int) (color.RGBA, int, complex128) {
+  kernel := cfg.pixelKernel(cols, rows, pre)
+  toPlane := cfg.pixelTransform(cols, rows).compose(pre)
+  roots := rootColors(cfg)
+  radius := cfg.escapeRadius()
+  bound := cfg.certainEscape()
+  // Distances inside the set shade from the boundary to this far in
+  falloff := interiorFalloff * (cfg.XMax - cfg.XMin) / float64(cfg.Cols)
+  return func(x, y float64, maxIter int) (color.RGBA, int, complex128) {
+    if kernel == nil {
+      re, im := toPlane.apply(x, y)
+      return newtonColor(cfg, roots, complex(re, im)), 0, 0
+    }
+    v, z := kernel(x, y, maxIter, radius)
+    escaped := v < maxIter
+    if cfg.Alpha && !escaped && !(cfg.Uncertain.A != 0 && uncertain(escaped, z, bound)) {
+      return color.RGBA{0, 0, 0, 0}, v, z
+    }
+    if cfg.InteriorColoring == "distance" && !escaped && !uncertain(escaped, z, bound) {
+      // Hand the interior palette a z as far out as the point is near
+      // the boundary, as depth coloring's farthest point would be
+      re, im := toPlane.apply(x, y)
+      t := 1.0
+      if d := interiorDistance(complex(re, im), z, maxIter); d >= 0 {
+        t = 1 - math.Min(1, d / falloff)
+      }
+      return colorer.Color(escaped, float64(v), complex(2 * t, 0)), v, z
+    }
+    return colorer.Color(escaped, float64(v), z), v, z
+  }
 }
 
-// Render chunks
-func work(i img, chunks chan rowRange, flag chan int) {
+// Render chunks of i, coloring points with colorer, sending each on done
+// when it's finished. Each pixel is the average of sx by sy samples, or as
+// many as effort, the maskEffort of its output pixel, allows if it's set.
+// The escapes of the samples nearest output pixels' centers go to
+// cfg.Escapes, if it's set. Once ctx is done, chunks are skipped but still
+// sent.
+func work(ctx context.Context, cfg RenderConfig, worker int, i img, sx, sy int, colorer Colorer, effort []float64, chunks chan workRect, done chan workRect) {
+  defer cfg.pinWorker(worker)()
+  colorAt := cfg.pointColorer(colorer, i.cols * sx, i.rows * sy, identity)
+  keep := cfg.escapeKeeper(i.cols, i.rows)
+  // For jitter, reseeded at each pixel of i to the stream sampleRNG would
+  // give it, rather than making one per pixel. Chunks split rows into
+  // strips by the worker count, so a stream per row would let each strip
+  // replay the row's first numbers. Streams go by the pixel's place in the
+  // whole image, in i's pixels.
+  pcg := rand.NewPCG(0, 0)
+  rng := rand.New(pcg)
+  offsetX, offsetY := cfg.Offset.X * i.cols / cfg.Cols, cfg.Offset.Y * i.rows / cfg.Rows
+  // Jitter is to 1/65536 of a cell, finer than shows, so adding it to x,y
+  // is exact, and a piece of the image rendered alone samples the same points
+  jitter := func() float64 {
+    return float64(rng.Uint32() >> 16) / (1 << 16)
+  }
+  // Sample the cell of the sample grid centered on x,y, w by h samples at
+  // full effort
+  sample := func(x, y, w, h float64, maxIter int) (color.RGBA, int, complex128) {
+    if cfg.Jitter {
+      x, y = x + jitter() * w - w / 2, y + jitter() * h - h / 2
+    }
+    return colorAt(x, y, maxIter)
+  }
   for {
     chunk, ok := <- chunks
     if !ok {
       break
     }
-    for r := chunk.startRow; r < chunk.stopRow; r++ {
-      y := linear(float64(r), 0.0, float64(i.rows-1), yMax, yMin)
-      for c := 0; c < i.cols; c++ {
-        x := linear(float64(c), 0.0, float64(i.cols-1), xMin, xMax)
-        v := mandelbrot(complex(x, y))
-        i.set(c, r, color.RGBA{0, uint8(v), uint8(v), 255})
+    start := time.Now()
+    for r := chunk.startRow; r < chunk.stopRow && ctx.Err() == nil; r++ {
+      for c := chunk.startCol; c < chunk.stopCol && ctx.Err() == nil; c++ {
+        if cfg.Jitter {
+          pcg.Seed(cfg.Seed, uint64(offsetY + r) << 32 | uint64(offsetX + c))
+        }
+        nx, ny, maxIter := sx, sy, cfg.Iterations
+        if effort != nil {
+          // i is output pixels, or supersampled ones if sx and sy are 1
+          nx, ny, maxIter = cfg.maskBudget(effort[r * sy / cfg.ScaleY * cfg.Cols + c * sx / cfg.ScaleX], sx, sy)
+        }
+        samples := nx * ny
+        // Spread nx by ny samples evenly over the pixel's sx by sy cells
+        w, h := float64(sx) / float64(nx), float64(sy) / float64(ny)
+        at := func(subCol, subRow int) color.RGBA {
+          x := float64(c * sx) + (float64(subCol) + 0.5) * w - 0.5
+          y := float64(r * sy) + (float64(subRow) + 0.5) * h - 0.5
+          px, v, z := sample(x, y, w, h, maxIter)
+          // The middle sample, or right and down of the middle
+          if keep != nil && subCol == nx / 2 && subRow == ny / 2 {
+            keep(c, r, v, maxIter, z)
+          }
+          return px
+        }
+        if samples == 1 {
+          i.set(c, r, at(0, 0))
+          continue
+        }
+        // Same as rendering nx by ny pixels and box-averaging them, but
+        // without the buffer
+        if cfg.LinearLight {
+          var sum [4]float64
+          for subRow := 0; subRow < ny; subRow++ {
+            for subCol := 0; subCol < nx; subCol++ {
+              sc := channels(at(subCol, subRow), true)
+              for k := range sum {
+                sum[k] += sc[k] / float64(samples)
+              }
+            }
+          }
+          i.set(c, r, fromChannels(sum, true))
+          continue
+        }
+        red, green, blue, alpha := 0, 0, 0, 0
+        for subRow := 0; subRow < ny; subRow++ {
+          for subCol := 0; subCol < nx; subCol++ {
+            sc := at(subCol, subRow)
+            red += int(sc.R)
+            green += int(sc.G)
+            blue += int(sc.B)
+            alpha += int(sc.A)
+          }
+        }
+        i.set(c, r, color.RGBA{uint8(red / samples), uint8(green / samples),
+          uint8(blue / samples), uint8(alpha / samples)})
       }
     }
+    slog.Debug("chunk done", "rows", fmt.Sprintf("%d-%d", chunk.startRow, chunk.stopRow),
+      "elapsed", time.Since(start))
+    done <- chunk
   }
-  flag <- 0 // Signal completion
 }
 
-func main() {
-  render := mkImg(imgCols * scale, imgRows * scale)
-  chunkRows := render.rows / chunkNum
+// Rendering
 
-  // Queue up chunks of work on a channel
-  chunks := make(chan rowRange, chunkNum)
-  startRow := 0;
-  stopRow := chunkRows
-  for i := 0; i < chunkNum-1; i++ {
-    chunks <- rowRange{startRow, stopRow}
-    startRow, stopRow = stopRow, stopRow + chunkRows
-  }
-  chunks <- rowRange{startRow, render.rows}
-  close(chunks)
+type RenderConfig struct {
+  Fractal string // Which fractal: "mandelbrot", "tricorn", "burningship", "julia", "multibrot", "newton", "buddhabrot", or a registered one
+  Degree int // Degree n of the polynomial: z^n - 1 for Newton, z^n + c for the multibrot
+  Julia [2]float64 // Real and imaginary parts of the julia set's constant
+  XMin, XMax, YMin, YMax float64 // Bounds in the complex plane
+  WrapX bool // Treat XMin to XMax as one period of a view repeating horizontally, so the image tiles side by side
+  Iterations int // Give up on a point escaping after this many iterations
+  EscapeRadius float64 // A point escapes once |z| exceeds this; 0 means escapeThresh
+  Norm string // What |z| means for escaping: "l2" (or ""), "linf", or "l1"
+  Precision string // Arithmetic to iterate in: "float64", "dd" for double-double, or "auto" (or "") for dd only when float64 runs out
+  Cols, Rows int // Output dimensions
+  ScaleX, ScaleY int // Supersample factors
+  Supersample float64 // If positive, supersample by this in both dimensions instead, whole or not, resampling with lanczos
+  Filter string // How to downsample: "box" (or "") or "lanczos"
+  AA string // "full" (or "") to supersample every pixel, or "smart" for just the detailed ones
+  AAThreshold float64 // Variance above which smart AA supersamples a pixel
+  LanczosA int // Lobes of the lanczos filter
+  Palette Palette
+  InteriorPalette Palette // If set, color points that never escape from this
+  InteriorColoring string // What picks a never-escaping point's interior palette color: where its orbit "end"s (or ""), its "depth", or its "distance" from the boundary
+  Uncertain color.RGBA // If not transparent, color points cut off by the iteration cap this
+  Inside color.RGBA // If not transparent, color points that never escape this, rather than the palette's first color
+  PaletteSpace string // Interpolate the palette in "srgb" or "oklab"
+  ColorScale string // Iteration to palette mapping: "linear", "log", or "sqrt"
+  ColorOffset float64 // Iterations to add to counts before mapping them to the palette
+  ColorPeriod float64 // If positive, run through the palette every this many iterations rather than once up to the cap
+  Coloring string // Name of a registered Colorer
+  Alpha bool // Make the interior of the set transparent
+  LinearLight bool // Average samples in linear light rather than sRGB
+  Jitter bool // Offset each sample randomly within its cell of the sample grid, trading aliasing for noise
+  Mask image.Image `json:"-"` // If set, its luminance under each output pixel sets the effort spent there, as by maskBudget
+  MmapFile string `json:"-"` // If set, back the supersampled buffer with this file
+  Workers int `json:"-"` // Render with this many goroutines
+  MaxProcs int `json:"-"` // If positive, cap GOMAXPROCS at this while rendering
+  Affinity bool `json:"-"` // Pin each worker to a CPU of its own, as far as there are CPUs
+  MaxMemory int64 `json:"-"` // If positive, refuse renders whose pixels need more bytes than this
+  Chunks int `json:"-"` // Divide the image into this many chunks, or 0 for the default
+  ChunkOrder string `json:"-"` // Order to render chunks in: "top" (or "") down, or "center" out
+  Samples int // Points to sample for the Buddhabrot, or 0 for the default
+  ToneMap string // How to show densities: "linear", "log", "gamma", or "reinhard"
+  Seed uint64 // For anything random
+  Offset image.Point `json:"-"` // Where output pixel 0,0 is in the whole image, if this renders a piece of one, so the piece's random streams are the whole's
+  ROI image.Rectangle // If not empty, only render these output pixels
+  Unrendered color.RGBA // Color of the output pixels outside ROI
+  Affine affine // Transform the view about its center; zero means identity
+  FullRes func(m image.Image) error `json:"-"` // If set, called with the supersampled image once it's done; m is only valid during the call
+  Overlays []Overlay `json:"-"` // Drawn in order on the output once it's downsampled
+  Escapes *EscapeMap `json:"-"` // If set, Cols by Rows, filled in with the escape of each output pixel as it's rendered
+}
 
-  // Create a channel for each worker on which to signal completion
-  flags := make([]chan int, workerNum)
-  for i := 0; i < workerNum; i++ {
-    flags[i] = make(chan int)
+func (cfg RenderConfig) validate() error {
+  if _, ok := fractals[cfg.Fractal]; !ok {
+    return fmt.Errorf("unknown fractal %q", cfg.Fractal)
+  }
+  if !(cfg.XMin < cfg.XMax && cfg.YMin < cfg.YMax) {
+    return fmt.Errorf("bad bounds %g,%g,%g,%g", cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax)
+  }
+  if (cfg.Fractal == "newton" || cfg.Fractal == "multibrot") && cfg.Degree < 2 {
+    return fmt.Errorf("degree must be at least 2, got %d", cfg.Degree)
+  }
+  if cfg.Iterations < 1 {
+    return fmt.Errorf("iterations must be at least 1, got %d", cfg.Iterations)
+  }
+  if _, ok := norms[cfg.Norm]; !ok && cfg.Norm != "" {
+    return fmt.Errorf("unknown norm %q", cfg.Norm)
+  }
+  switch cfg.Precision {
+  case "", "auto", "float64":
+  case "dd":
+    if cfg.Fractal != "mandelbrot" {
+      return fmt.Errorf("double-double precision is only for the mandelbrot set, not %s", cfg.Fractal)
+    }
+  default:
+    return fmt.Errorf("unknown precision %q", cfg.Precision)
+  }
+  // Points of the set can get this far, so they'd be taken for escaping;
+  // |z| = 2 is as far as 2 * sqrt(2) in L1
+  minRadius := 2.0
+  if cfg.Norm == "l1" {
+    minRadius = 2 * math.Sqrt2
+  }
+  if cfg.EscapeRadius != 0 && cfg.EscapeRadius < minRadius {
+    return fmt.Errorf("escape radius must be at least %.4g, got %g", minRadius, cfg.EscapeRadius)
+  }
+  if cfg.Cols < 1 || cfg.Rows < 1 {
+    return fmt.Errorf("image must be at least 1x1, got %dx%d", cfg.Cols, cfg.Rows)
+  }
+  if cfg.ScaleX < 1 || cfg.ScaleY < 1 {
+    return fmt.Errorf("scale must be at least 1, got %dx%d", cfg.ScaleX, cfg.ScaleY)
+  }
+  if cfg.Supersample != 0 {
+    if cfg.Supersample < 1 {
+      return fmt.Errorf("supersample must be at least 1, got %g", cfg.Supersample)
+    }
+    if cfg.AA == "smart" || !cfg.ROI.Empty() || cfg.Mask != nil {
+      return errors.New("a fractional supersample can't be combined with smart AA, a region, or a mask")
+    }
+  }
+  if !cfg.ROI.Empty() && !cfg.ROI.In(image.Rect(0, 0, cfg.Cols, cfg.Rows)) {
+    return fmt.Errorf("region %v outside %dx%d image", cfg.ROI, cfg.Cols, cfg.Rows)
+  }
+  switch cfg.AA {
+  case "", "full":
+  case "smart":
+    if !cfg.ROI.Empty() || cfg.FullRes != nil || cfg.Fractal == "buddhabrot" || cfg.Jitter || cfg.Mask != nil {
+      return errors.New("smart AA can't be combined with a region, a full-res image, the buddhabrot, jitter, or a mask")
+    }
+  default:
+    return fmt.Errorf("unknown AA %q", cfg.AA)
+  }
+  switch cfg.Filter {
+  case "", "box":
+  case "lanczos":
+    if cfg.LanczosA < 1 {
+      return fmt.Errorf("lanczos filter needs at least 1 lobe, got %d", cfg.LanczosA)
+    }
+  default:
+    return fmt.Errorf("unknown filter %q", cfg.Filter)
+  }
+  if e := cfg.Escapes; e != nil {
+    if e.Cols != cfg.Cols || e.Rows != cfg.Rows {
+      return fmt.Errorf("escape map is %dx%d, not the image's %dx%d", e.Cols, e.Rows, cfg.Cols, cfg.Rows)
+    }
+    if fractals[cfg.Fractal].newFractal == nil {
+      return fmt.Errorf("%s has no escapes to keep", cfg.Fractal)
+    }
+    if cfg.Iterations > math.MaxInt32 {
+      return fmt.Errorf("escape maps hold counts up to %d, not %d", math.MaxInt32, cfg.Iterations)
+    }
+  }
+  if cfg.Mask != nil && cfg.Fractal == "buddhabrot" {
+    return errors.New("the buddhabrot doesn't sample per pixel, so it can't take a mask")
+  }
+  if !cfg.ROI.Empty() && cfg.Fractal == "buddhabrot" {
+    return errors.New("the buddhabrot's orbits cross the whole image, so it can't render just a region")
+  }
+  if (cfg.Alpha || cfg.Inside.A != 0) && cfg.Fractal == "buddhabrot" {
+    return errors.New("the buddhabrot colors by density, with no interior for alpha or an inside color")
+  }
+  if cfg.WrapX && cfg.Fractal == "buddhabrot" {
+    return errors.New("the buddhabrot plots orbits rather than sampling columns, so it can't wrap")
+  }
+  if cfg.Workers < 1 {
+    return fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+  }
+  if cfg.MaxProcs < 0 {
+    return fmt.Errorf("max procs must not be negative, got %d", cfg.MaxProcs)
+  }
+  if cfg.Samples < 0 {
+    return fmt.Errorf("samples must not be negative, got %d", cfg.Samples)
+  }
+  if !validToneMap(cfg.ToneMap) {
+    return fmt.Errorf("unknown tone map %q", cfg.ToneMap)
+  }
+  if cfg.Chunks < 0 {
+    return fmt.Errorf("chunks must not be negative, got %d", cfg.Chunks)
+  }
+  if cfg.ChunkOrder != "" && cfg.ChunkOrder != "top" && cfg.ChunkOrder != "center" {
+    return fmt.Errorf("unknown chunk order %q", cfg.ChunkOrder)
   }
+  if len(cfg.Palette) < 2 {
+    return errors.New("palette needs at least 2 colors")
+  }
+  if cfg.InteriorPalette != nil && len(cfg.InteriorPalette) < 2 {
+    return errors.New("interior palette needs at least 2 colors")
+  }
+  if cfg.InteriorPalette != nil && cfg.Alpha {
+    return errors.New("interior palette and alpha both color the interior")
+  }
+  if cfg.Inside.A != 0 && (cfg.InteriorPalette != nil || cfg.Alpha) {
+    return errors.New("inside color and interior palette or alpha both color the interior")
+  }
+  switch cfg.InteriorColoring {
+  case "", "end":
+  case "depth", "distance":
+    if cfg.InteriorPalette == nil {
+      return fmt.Errorf("%s interior coloring needs an interior palette", cfg.InteriorColoring)
+    }
+    if cfg.InteriorColoring == "distance" && cfg.Fractal != "mandelbrot" {
+      return fmt.Errorf("distance interior coloring is only for the mandelbrot set, not %s", cfg.Fractal)
+    }
+  default:
+    return fmt.Errorf("unknown interior coloring %q", cfg.InteriorColoring)
+  }
+  if colorers[cfg.Coloring] == nil {
+    return fmt.Errorf("unknown coloring %q", cfg.Coloring)
+  }
+  if cfg.PaletteSpace != "srgb" && cfg.PaletteSpace != "oklab" {
+    return fmt.Errorf("unknown palette space %q", cfg.PaletteSpace)
+  }
+  if colorScales[cfg.ColorScale] == nil {
+    return fmt.Errorf("unknown color scale %q", cfg.ColorScale)
+  }
+  if cfg.ColorPeriod < 0 {
+    return fmt.Errorf("color period must not be negative, got %g", cfg.ColorPeriod)
+  }
+  // Last, since it relies on the rest being sane
+  if need := cfg.memoryNeeded(); cfg.MaxMemory > 0 && need > cfg.MaxMemory {
+    hint := "a smaller scale"
+    if cfg.buffered() && cfg.MmapFile == "" {
+      hint = "a smaller scale, the box filter, or an mmap file"
+    }
+    return fmt.Errorf("render needs about %d MiB, more than the %d MiB allowed; try %s",
+      need >> 20, cfg.MaxMemory >> 20, hint)
+  }
+  return nil
+}
+
+// Roughly how many bytes of pixels rendering cfg allocates: the output and
+// the image rendered into, which is supersampled if it's buffered in memory,
+// or for the buddhabrot, a density count per worker
+func (cfg RenderConfig) memoryNeeded() int64 {
+  out := int64(cfg.Cols) * int64(cfg.Rows) * 4 // Bytes per pixel, or count
+  switch {
+  case cfg.Fractal == "buddhabrot":
+    return out * int64(cfg.Workers + 2)
+  case cfg.AA == "smart":
+    return out * 3
+  case cfg.buffered() && cfg.MmapFile == "":
+    cols, rows := cfg.renderSize()
+    return out + int64(cols) * int64(rows) * 4
+  }
+  return out * 2
+}
 
-  // Start workers
-  for i := 0; i < workerNum; i++ {
-    go work(render, chunks, flags[i])
+// Pick an iteration cap for the bounds: the fractal's own at its default
+// width, plus 100 for every 10x of zoom beyond it
+func (cfg RenderConfig) autoIterations() int {
+  f := fractals[cfg.Fractal]
+  zoom := (f.bounds[1] - f.bounds[0]) / (cfg.XMax - cfg.XMin)
+  if zoom <= 1 {
+    return f.iterations
   }
+  return f.iterations + int(100 * math.Log10(zoom))
+}
+
+// Mapping from pixel coordinates in a cols x rows image to the complex plane
+func (cfg RenderConfig) pixelTransform(cols, rows int) affine {
+  return cfg.viewTransform(cols, rows, 0, 0)
+}
 
-  // Wait for workers to finish
-  for i := 0; i < workerNum; i++ {
-    <- flags[i]
+// Like pixelTransform, but to offsets from ox,oy in the plane. Taking ox,oy
+// off the bounds before anything else keeps the offsets as precise as the
+// bounds are when ox,oy is near them.
+func (cfg RenderConfig) viewTransform(cols, rows int, ox, oy float64) affine {
+  // Map columns linearly onto XMin to XMax and rows onto YMax to YMin. A
+  // single column or row is at XMin or YMax. Wrapping, XMax is where the
+  // next copy of the view starts, past the last column.
+  xSlope := (cfg.XMax - cfg.XMin) / float64(max(1, cols - 1))
+  if cfg.WrapX {
+    xSlope = (cfg.XMax - cfg.XMin) / float64(cols)
+  }
+  ySlope := (cfg.YMin - cfg.YMax) / float64(max(1, rows - 1))
+  bounds := affine{xSlope, 0, cfg.XMin - ox, 0, ySlope, cfg.YMax - oy}
+  if cfg.Affine == identity || cfg.Affine == (affine{}) {
+    return bounds
   }
 
-  renderSmall := downScale(render, scale)
+  // Move the center to the origin, transform, and move it back
+  cx, cy := (cfg.XMin + cfg.XMax) / 2 - ox, (cfg.YMin + cfg.YMax) / 2 - oy
+  toOrigin := affine{1, 0, -cx, 0, 1, -cy}
+  fromOrigin := affine{1, 0, cx, 0, 1, cy}
+  return fromOrigin.compose(cfg.Affine.compose(toOrigin.compose(bounds)))
+}
 
-  outFile, err := os.Create(outFileName)
-  defer outFile.Close()
-  if err != nil {
-    fmt.Println(err)
-    return
+// The size of the supersampled image: the output's times ScaleX by ScaleY,
+// or with a Supersample, times that, rounded up
+func (cfg RenderConfig) renderSize() (cols, rows int) {
+  if cfg.Supersample > 0 {
+    return int(math.Ceil(float64(cfg.Cols) * cfg.Supersample)), int(math.Ceil(float64(cfg.Rows) * cfg.Supersample))
+  }
+  return cfg.Cols * cfg.ScaleX, cfg.Rows * cfg.ScaleY
+}
+
+// Mapping from output pixel coordinates to supersampled ones. An output
+// pixel maps to the center of the supersampled pixels averaged into it.
+func (cfg RenderConfig) outputScale() affine {
+  cols, rows := cfg.renderSize()
+  sx, sy := float64(cols) / float64(cfg.Cols), float64(rows) / float64(cfg.Rows)
+  return affine{sx, 0, (sx - 1) / 2, 0, sy, (sy - 1) / 2}
+}
+
+// Mapping from output pixel coordinates to the complex plane
+func (cfg RenderConfig) outputTransform() affine {
+  return cfg.pixelTransform(cfg.renderSize()).compose(cfg.outputScale())
+}
+
+// The point in the complex plane at the center of output pixel x,y
+func (cfg RenderConfig) PixelToComplex(x, y int) complex128 {
+  re, im := cfg.outputTransform().apply(float64(x), float64(y))
+  return complex(re, im)
+}
+
+// The output pixel nearest c, which may be outside the image
+func (cfg RenderConfig) ComplexToPixel(c complex128) (x, y int) {
+  fx, fy := cfg.outputTransform().invert().apply(real(c), imag(c))
+  return int(math.Round(fx)), int(math.Round(fy))
+}
+
+// How many representable float64s lie between neighboring supersampled
+// pixels near the view. Much below precisionWarn, neighboring pixels round
+// to the same few points and the image blocks up.
+func (cfg RenderConfig) pixelPrecision() float64 {
+  m := cfg.pixelTransform(cfg.renderSize())
+  spacing := math.Min(math.Hypot(m[0], m[3]), math.Hypot(m[1], m[4]))
+  mag := math.Max(math.Max(math.Abs(cfg.XMin), math.Abs(cfg.XMax)),
+    math.Max(math.Abs(cfg.YMin), math.Abs(cfg.YMax)))
+  ulp := math.Nextafter(mag, math.Inf(1)) - mag
+  return spacing / ulp
+}
+
+// Whether a render needs the whole supersampled image in memory, rather than
+// averaging samples straight into each output pixel: to filter with more
+// than the samples within a pixel, to keep it, or because it was asked for
+// in a file
+func (cfg RenderConfig) buffered() bool {
+  return cfg.Filter == "lanczos" || cfg.Supersample > 0 || cfg.FullRes != nil || cfg.MmapFile != ""
+}
+
+// Whether every pixel of cfg's render is sure to be opaque, as far as can be
+// told without rendering it
+func (cfg RenderConfig) opaque() bool {
+  if cfg.Alpha || cfg.Uncertain.A != 0 && cfg.Uncertain.A != 255 || cfg.Inside.A != 0 && cfg.Inside.A != 255 ||
+    !cfg.ROI.Empty() && cfg.Unrendered.A != 255 {
+    return false
+  }
+  for _, p := range []Palette{cfg.Palette, cfg.InteriorPalette} {
+    for _, c := range p {
+      if c.A != 255 {
+        return false
+      }
+    }
+  }
+  return true
+}
+
+// How many samples the workers average into each pixel of the image they
+// render into, in each dimension
+func (cfg RenderConfig) pixelSamples() (sx, sy int) {
+  if cfg.buffered() {
+    return 1, 1
   }
+  return cfg.ScaleX, cfg.ScaleY
+}
+
+// The area to render, in pixels of the image the workers render into
+func (cfg RenderConfig) renderArea() image.Rectangle {
+  if cfg.Supersample > 0 {
+    cols, rows := cfg.renderSize()
+    return image.Rect(0, 0, cols, rows)
+  }
+  sx, sy := cfg.pixelSamples()
+  rx, ry := cfg.ScaleX / sx, cfg.ScaleY / sy
+  if cfg.ROI.Empty() {
+    return image.Rect(0, 0, cfg.Cols * rx, cfg.Rows * ry)
+  }
+  return image.Rect(
+    cfg.ROI.Min.X * rx, cfg.ROI.Min.Y * ry, cfg.ROI.Max.X * rx, cfg.ROI.Max.Y * ry)
+}
 
-  outWriter := bufio.NewWriter(outFile)
-  err = png.Encode(outWriter, renderSmall)
+// How many points a render iterates, and the most workers busy at once. For
+// smart AA, which only knows how many pixels to refine as it goes, just the
+// points of its first pass.
+func (cfg RenderConfig) workload() (points, workers int) {
+  if cfg.AA == "smart" {
+    return cfg.Cols * cfg.Rows, min(cfg.Workers, cfg.Rows)
+  }
+  if cfg.Fractal == "buddhabrot" {
+    points = cfg.Samples
+    if points == 0 {
+      points = cfg.Cols * cfg.Rows * samplesPerPixel
+    }
+    return points, min(cfg.Workers, (points + buddhaBatch - 1) / buddhaBatch)
+  }
+  area := cfg.renderArea()
+  sx, sy := cfg.pixelSamples()
+  rects, _ := cfg.chunkRects(area)
+  return area.Dx() * area.Dy() * sx * sy, min(cfg.Workers, len(rects))
+}
+
+// Renders running under a GOMAXPROCS cap, and the value to restore when the
+// last of them is done
+var procsCap struct {
+  sync.Mutex
+  renders int
+  prev int
+}
+
+// Cap GOMAXPROCS at n until the returned function is called. Overlapping
+// renders, as in the server, share the cap: the first sets it, later ones
+// set their own n, and the last to finish puts back what was there before
+// the first.
+func capProcs(n int) (restore func()) {
+  procsCap.Lock()
+  defer procsCap.Unlock()
+  if procsCap.renders == 0 {
+    procsCap.prev = runtime.GOMAXPROCS(n)
+  } else {
+    runtime.GOMAXPROCS(n)
+  }
+  procsCap.renders++
+  return func() {
+    procsCap.Lock()
+    defer procsCap.Unlock()
+    procsCap.renders--
+    if procsCap.renders == 0 {
+      runtime.GOMAXPROCS(procsCap.prev)
+    }
+  }
+}
+
+// If cfg asks for Affinity, pin the calling worker, the worker'th, to a CPU,
+// returning the function to unpin it. Failing that it just works unpinned.
+func (cfg RenderConfig) pinWorker(worker int) (unpin func()) {
+  if !cfg.Affinity || !affinitySupported {
+    return func() {}
+  }
+  restore, err := pinWorker(worker)
   if err != nil {
-    fmt.Println(err)
-    return
+    slog.Warn("can't pin worker to a CPU", "worker", worker, "err", err)
+    return func() {}
+  }
+  return restore
+}
+
+func (cfg RenderConfig) escapeRadius() float64 {
+  if cfg.EscapeRadius == 0 {
+    return escapeThresh
+  }
+  return cfg.EscapeRadius
+}
+
+// Divide area into chunks: bands of full-width rows, in order from the top.
+// Only if there are fewer rows than chunks wanted, as in a short, wide
+// region, are the bands split into strips of columns too, to keep more
+// workers busy.
+func (cfg RenderConfig) chunkRects(area image.Rectangle) (rects []workRect, strips int) {
+  n := cfg.Chunks
+  if n == 0 {
+    n = cfg.Workers * chunksPerWorker
+  }
+  bands := max(1, min(n, area.Dy()))
+  strips = max(1, min((n + bands - 1) / bands, area.Dx()))
+  for b := 0; b < bands; b++ {
+    startRow := area.Min.Y + b * area.Dy() / bands
+    stopRow := area.Min.Y + (b + 1) * area.Dy() / bands
+    for s := 0; s < strips; s++ {
+      startCol := area.Min.X + s * area.Dx() / strips
+      stopCol := area.Min.X + (s + 1) * area.Dx() / strips
+      rects = append(rects, workRect{startRow, stopRow, startCol, stopCol})
+    }
+  }
+  return rects, strips
+}
+
+// The order to queue rects for rendering in, as ChunkOrder says. Center out
+// gets the middle of the view, usually the part being looked at, done
+// first.
+func (cfg RenderConfig) chunkOrder(rects []workRect, area image.Rectangle) []workRect {
+  if cfg.ChunkOrder != "center" {
+    return rects
+  }
+  // Twice the distance, to keep to integers
+  cx, cy := area.Min.X + area.Max.X, area.Min.Y + area.Max.Y
+  dist := func(r workRect) int {
+    dx, dy := r.startCol + r.stopCol - cx, r.startRow + r.stopRow - cy
+    return dx * dx + dy * dy
+  }
+  ordered := slices.Clone(rects)
+  slices.SortStableFunc(ordered, func(a, b workRect) int {
+    return dist(a) - dist(b)
+  })
+  return ordered
+}
+
+// Render the configured view, supersampled and then scaled down, into memory.
+// Writing it anywhere is up to the caller; it's an ordinary image.Image with
+// alpha-premultiplied color.RGBA pixels, so any standard encoder can encode
+// it. Errors are all *RenderError.
+func Render(cfg RenderConfig) (image.Image, error) {
+  return RenderContext(context.Background(), cfg)
+}
+
+// Render, giving up if ctx is done first. Workers stop within a pixel, and
+// all of them have by the time it returns ctx.Err() itself. Other errors
+// are all *RenderError.
+func RenderContext(ctx context.Context, cfg RenderConfig) (image.Image, error) {
+  r := NewRenderer(cfg)
+  for range r.Start(ctx) {
+  }
+  return r.Result()
+}
+
+// Render, giving up with ctx's error if it's done first
+func renderContext(ctx context.Context, cfg RenderConfig) (img, error) {
+  return renderProgress(ctx, cfg, nil)
+}
+
+// Render the configured view, calling emit with each output row as soon as
+// it's done, in order from the top. pixels is only valid during the call.
+// Errors are all *RenderError.
+func RenderStream(cfg RenderConfig, emit func(row int, pixels []color.RGBA)) error {
+  if len(cfg.Overlays) > 0 {
+    return &RenderError{cfg, errors.New("overlays need the whole image, so can't be streamed")}
+  }
+  return renderStream(context.Background(), cfg, emit)
+}
+
+func renderStream(ctx context.Context, cfg RenderConfig, emit func(row int, pixels []color.RGBA)) (err error) {
+  defer func() {
+    if err != nil {
+      err = &RenderError{cfg, err}
+    }
+  }()
+  if err := cfg.validate(); err != nil {
+    return err
+  }
+  if cfg.MaxProcs > 0 {
+    defer capProcs(cfg.MaxProcs)()
+  }
+  if cfg.Affinity && !affinitySupported {
+    slog.Warn("-affinity is only supported on linux; rendering with workers unpinned")
+  }
+  if p := cfg.pixelPrecision(); p < precisionWarn && cfg.precision() == "float64" {
+    slog.Warn("zoomed in past float64 precision; expect blocky output",
+      "floats_per_pixel", p)
+  }
+  if cfg.AA == "smart" {
+    return renderSmart(ctx, cfg, emit)
+  }
+  if cfg.Fractal == "buddhabrot" {
+    m, err := renderBuddhabrot(ctx, cfg)
+    if err != nil {
+      return err
+    }
+    for row := 0; row < m.rows; row++ {
+      emit(row, m.px[row * m.cols:(row + 1) * m.cols])
+    }
+    return nil
+  }
+
+  start := time.Now()
+  var render img
+  var scaler rowScaler
+  if cfg.buffered() {
+    if cfg.MmapFile != "" {
+      var unmap func() error
+      var err error
+      cols, rows := cfg.renderSize()
+      render, unmap, err = mkImgMapped(cols, rows, cfg.MmapFile)
+      if err != nil {
+        return err
+      }
+      defer unmap()
+    } else {
+      render = mkPooledImg(cfg.renderSize())
+      defer freeImg(render)
+    }
+    var err error
+    scaler, err = cfg.rowScaler(render)
+    if err != nil {
+      return err
+    }
+  } else {
+    render = mkPooledImg(cfg.Cols, cfg.Rows)
+    defer freeImg(render)
+    scaler = copyScaler{render}
+  }
+  sx, sy := cfg.pixelSamples()
+  slog.Info("render start", "cols", render.cols, "rows", render.rows,
+    "samples", fmt.Sprintf("%dx%d", sx, sy), "iterations", cfg.Iterations, "workers", cfg.Workers)
+  area := cfg.renderArea()
+  rects, strips := cfg.chunkRects(area)
+
+  // Queue up chunks of work on a channel
+  chunks := make(chan workRect, len(rects))
+  for _, r := range cfg.chunkOrder(rects, area) {
+    chunks <- r
+  }
+  close(chunks)
+
+  // Start workers. Every chunk can be done before some of them get going,
+  // so wait for them to stop too, rather than just for the chunks.
+  done := make(chan workRect, len(rects))
+  colorer := newColorer(cfg)
+  effort := cfg.maskEffort()
+  var workers sync.WaitGroup
+  defer workers.Wait()
+  for i := 0; i < cfg.Workers; i++ {
+    workers.Go(func() {
+      work(ctx, cfg, i, render, sx, sy, colorer, effort, chunks, done)
+    })
+  }
+
+  // Chunks finish out of order. ready is the number of rows from the top
+  // known to be done; rows outside the area are done already. A band of rows
+  // is done once all its strips are.
+  type band struct {
+    stopRow int
+    left int // Strips not yet done
+  }
+  bands := make(map[int]*band) // By startRow
+  for i := 0; i < len(rects); i += strips {
+    bands[rects[i].startRow] = &band{rects[i].stopRow, strips}
+  }
+  ready := area.Min.Y
+  outRow := 0
+  pixels := make([]color.RGBA, cfg.Cols)
+  for range rects {
+    chunk := <- done
+    bands[chunk.startRow].left--
+    for b := bands[ready]; b != nil && b.left == 0; b = bands[ready] {
+      ready = b.stopRow
+    }
+    if ready == area.Max.Y {
+      ready = render.rows
+    }
+    for ; ctx.Err() == nil && outRow < cfg.Rows && scaler.need(outRow) <= ready; outRow++ {
+      scaler.row(outRow, pixels)
+      if !cfg.ROI.Empty() {
+        for x := range pixels {
+          if !image.Pt(x, outRow).In(cfg.ROI) {
+            pixels[x] = cfg.Unrendered
+          }
+        }
+      }
+      emit(outRow, pixels)
+    }
+  }
+  if err := ctx.Err(); err != nil {
+    return err
+  }
+  slog.Info("render done", "elapsed", time.Since(start))
+  if cfg.FullRes != nil {
+    return cfg.FullRes(render)
   }
-  outWriter.Flush()
+  return nil
 }
@@ -0,0 +1,469 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "flag"
+  "image"
+  "image/color"
+  "math"
+  "runtime"
+  "slices"
+  "testing"
+  "time"
+)
+
+// A small render, 60x40 at 2x supersampling, set up by flags as the render
+// command would be
+func testConfig(t *testing.T, args ...string) RenderConfig {
+  t.Helper()
+  fs := flag.NewFlagSet("test", flag.ContinueOnError)
+  config := renderFlags(fs)
+  if err := fs.Parse(append([]string{"-scale", "2"}, args...)); err != nil {
+    t.Fatal(err)
+  }
+  cfg, err := config()
+  if err != nil {
+    t.Fatal(err)
+  }
+  cfg.Cols, cfg.Rows = 60, 40
+  return cfg
+}
+
+// The pixels of cfg's render
+func testRender(t *testing.T, cfg RenderConfig) []color.RGBA {
+  t.Helper()
+  m, err := renderContext(context.Background(), cfg)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return m.px
+}
+
+// Half-transparent blocks average to their mean alpha, and premultiplied
+// color with it
+func TestDownScaleAveragesAlpha(t *testing.T) {
+  m := image.NewRGBA(image.Rect(0, 0, 4, 2))
+  // Left block: two opaque white pixels and two transparent ones
+  m.SetRGBA(0, 0, color.RGBA{255, 255, 255, 255})
+  m.SetRGBA(1, 1, color.RGBA{255, 255, 255, 255})
+  // Right block: all half-transparent red, premultiplied
+  for y := 0; y < 2; y++ {
+    for x := 2; x < 4; x++ {
+      m.SetRGBA(x, y, color.RGBA{128, 0, 0, 128})
+    }
+  }
+  out, err := DownScale(m, 2, 2)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, c := range []struct {
+    x int
+    want color.RGBA
+  }{
+    {0, color.RGBA{127, 127, 127, 127}},
+    {1, color.RGBA{128, 0, 0, 128}},
+  } {
+    if got := out.RGBAAt(c.x, 0); got != c.want {
+      t.Errorf("pixel %d: got %v, want %v", c.x, got, c.want)
+    }
+  }
+}
+
+// The random parts of a render come out the same for a seed with any number
+// of workers, and differently for another seed. The image is wide and
+// short, so its rows are split into strips, more of them with more workers.
+func TestSeedIndependentOfWorkers(t *testing.T) {
+  for _, args := range [][]string{
+    {"-jitter"},
+    {"-jitter", "-filter", "lanczos"},
+    {"-fractal", "buddhabrot", "-samples", "100000"},
+  } {
+    cfg := testConfig(t, append(args, "-seed", "7")...)
+    cfg.Cols, cfg.Rows = 200, 6
+    cfg.Workers = 1
+    one := testRender(t, cfg)
+    cfg.Workers = 4
+    if four := testRender(t, cfg); !slices.Equal(one, four) {
+      t.Errorf("%v: 1 and 4 workers differ", args)
+    }
+    cfg.Seed = 8
+    if other := testRender(t, cfg); slices.Equal(one, other) {
+      t.Errorf("%v: seeds 7 and 8 give the same image", args)
+    }
+  }
+}
+
+// Pixel centers map back to their own pixels, as do points up to just under
+// half a pixel off them
+func TestPixelRoundTrip(t *testing.T) {
+  for _, args := range [][]string{
+    nil,
+    {"-bounds", "-0.75,-0.74,0.1,0.11"},
+    {"-scale", "2.5"},
+    {"-affine", "0.8,-0.6,0,0.6,0.8,0"},
+    {"-wrap-x"},
+  } {
+    cfg := testConfig(t, args...)
+    m := cfg.outputTransform()
+    for y := 0; y < cfg.Rows; y++ {
+      for x := 0; x < cfg.Cols; x++ {
+        for _, d := range [][2]float64{{0, 0}, {0.49, 0}, {0, -0.49}, {-0.49, 0.49}} {
+          re, im := m.apply(float64(x) + d[0], float64(y) + d[1])
+          c := complex(re, im)
+          if d == [2]float64{} {
+            c = cfg.PixelToComplex(x, y)
+          }
+          if px, py := cfg.ComplexToPixel(c); px != x || py != y {
+            t.Fatalf("%v: pixel %d,%d offset by %v came back as %d,%d", args, x, y, d, px, py)
+          }
+        }
+      }
+    }
+  }
+}
+
+// No pixel of a clean render is the old red sentinel, or the transparent
+// black At gives outside the image, along any of the render paths
+func TestNoSentinelPixels(t *testing.T) {
+  red := color.RGBA{255, 0, 0, 255}
+  for _, args := range [][]string{
+    nil,
+    {"-aa", "smart"},
+    {"-filter", "lanczos"},
+    {"-scale", "2.5"},
+    {"-fractal", "newton"},
+    {"-fractal", "buddhabrot"},
+  } {
+    for i, c := range testRender(t, testConfig(t, args...)) {
+      if c == red || c == (color.RGBA{}) {
+        t.Fatalf("%v: pixel %d is %v", args, i, c)
+      }
+    }
+  }
+}
+
+// Reading outside the image is a bug, so get panics rather than return a
+// color
+func TestGetPanicsOutOfBounds(t *testing.T) {
+  i := mkImg(3, 2)
+  for _, p := range []image.Point{{-1, 0}, {3, 0}, {0, -1}, {0, 2}, {3, 2}} {
+    func() {
+      defer func() {
+        if recover() == nil {
+          t.Errorf("get(%d, %d) of a 3x2 image didn't panic", p.X, p.Y)
+        }
+      }()
+      i.get(p.X, p.Y)
+    }()
+  }
+  if i.At(3, 0) != (color.RGBA{}) {
+    t.Errorf("At(3, 0) of a 3x2 image is %v, want transparent", i.At(3, 0))
+  }
+}
+
+// A cols x rows checkerboard of cell by cell squares of a and b, a at the
+// top left
+func checkerboard(cols, rows, cell int, a, b color.RGBA) *image.RGBA {
+  m := image.NewRGBA(image.Rect(0, 0, cols, rows))
+  for y := 0; y < rows; y++ {
+    for x := 0; x < cols; x++ {
+      c := a
+      if (x / cell + y / cell) % 2 == 1 {
+        c = b
+      }
+      m.SetRGBA(x, y, c)
+    }
+  }
+  return m
+}
+
+func TestDownScaleSynthetic(t *testing.T) {
+  white, black := color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255}
+  teal := color.RGBA{0, 128, 128, 255}
+  for _, c := range []struct {
+    name string
+    in image.Image
+    scaleX, scaleY int
+    want func(x, y int) color.RGBA
+  }{
+    {"solid", checkerboard(12, 6, 1, teal, teal), 3, 2, func(x, y int) color.RGBA { return teal }},
+    {"fine checkerboard", checkerboard(8, 8, 1, white, black), 2, 2,
+      func(x, y int) color.RGBA { return color.RGBA{127, 127, 127, 255} }},
+    {"checkerboard on the boxes", checkerboard(8, 8, 2, white, black), 2, 2, func(x, y int) color.RGBA {
+      if (x + y) % 2 == 1 {
+        return black
+      }
+      return white
+    }},
+    // Boxes of 2 columns and 1 row over 1-pixel cells average across each
+    // row's pairs only
+    {"uneven scale", checkerboard(8, 4, 1, white, black), 2, 1,
+      func(x, y int) color.RGBA { return color.RGBA{127, 127, 127, 255} }},
+    {"subimage", checkerboard(9, 9, 2, white, black).SubImage(image.Rect(1, 1, 9, 9)), 4, 4,
+      func(x, y int) color.RGBA { return color.RGBA{127, 127, 127, 255} }},
+  } {
+    out, err := DownScale(c.in, c.scaleX, c.scaleY)
+    if err != nil {
+      t.Errorf("%s: %v", c.name, err)
+      continue
+    }
+    b := c.in.Bounds()
+    if want := image.Rect(0, 0, b.Dx() / c.scaleX, b.Dy() / c.scaleY); out.Bounds() != want {
+      t.Errorf("%s: bounds %v, want %v", c.name, out.Bounds(), want)
+      continue
+    }
+    for y := 0; y < out.Bounds().Dy(); y++ {
+      for x := 0; x < out.Bounds().Dx(); x++ {
+        if got := out.RGBAAt(x, y); got != c.want(x, y) {
+          t.Errorf("%s: pixel %d,%d is %v, want %v", c.name, x, y, got, c.want(x, y))
+        }
+      }
+    }
+  }
+
+  if _, err := DownScale(checkerboard(9, 8, 1, white, black), 2, 2); err == nil {
+    t.Error("scaling 9 columns by 2 didn't fail")
+  }
+}
+
+// Empty images and scales below 1 are errors rather than panics or garbage,
+// and a single pixel renders like any other
+func TestDegenerateImages(t *testing.T) {
+  for _, c := range []struct {
+    name string
+    set func(cfg *RenderConfig)
+  }{
+    {"no columns", func(cfg *RenderConfig) { cfg.Cols = 0 }},
+    {"no rows", func(cfg *RenderConfig) { cfg.Rows = 0 }},
+    {"negative size", func(cfg *RenderConfig) { cfg.Cols, cfg.Rows = -4, -4 }},
+    {"zero scale", func(cfg *RenderConfig) { cfg.ScaleX = 0 }},
+    {"negative scale", func(cfg *RenderConfig) { cfg.ScaleY = -2 }},
+    {"supersample below 1", func(cfg *RenderConfig) { cfg.Supersample = 0.5 }},
+  } {
+    cfg := testConfig(t)
+    c.set(&cfg)
+    func() {
+      defer func() {
+        if p := recover(); p != nil {
+          t.Errorf("%s: render panicked: %v", c.name, p)
+        }
+      }()
+      _, err := Render(cfg)
+      var renderErr *RenderError
+      if !errors.As(err, &renderErr) {
+        t.Errorf("%s: got error %v, want a *RenderError", c.name, err)
+      }
+    }()
+  }
+
+  // One pixel, sampled once, is at the top left of the bounds
+  cfg := testConfig(t, "-scale", "1")
+  cfg.Cols, cfg.Rows = 1, 1
+  px := testRender(t, cfg)
+  v, z := cfg.kernel()(complex(cfg.XMin, cfg.YMax), cfg.Iterations, cfg.escapeRadius())
+  if want := newColorer(cfg).Color(v < cfg.Iterations, float64(v), z); px[0] != want {
+    t.Errorf("1x1 render is %v, want %v", px[0], want)
+  }
+
+  m := checkerboard(4, 4, 1, color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255})
+  for name, f := range map[string]func() error{
+    "DownScale by 0": func() error {
+      _, err := DownScale(m, 0, 2)
+      return err
+    },
+    "DownScaleFrom with no whole boxes": func() error {
+      _, err := DownScaleFrom(m, 8, 8, image.Point{}, false)
+      return err
+    },
+    "Resize to 0x0": func() error {
+      _, err := Resize(m, 0, 0)
+      return err
+    },
+  } {
+    if f() == nil {
+      t.Errorf("%s didn't fail", name)
+    }
+  }
+}
+
+// Known escapes, and points landing exactly on the radius, which haven't
+// escaped yet
+func TestEscapeTime(t *testing.T) {
+  // Smoothed from the count and the z past the radius it escaped at
+  smooth := func(iter int, z, radius float64) float64 {
+    return float64(iter) + 1 - math.Log2(math.Log(z) / math.Log(radius))
+  }
+  for _, c := range []struct {
+    c complex128
+    maxIter int
+    radius float64
+    iter int
+    smooth float64
+    escaped bool
+  }{
+    {0, 50, 2, 50, 50, false},
+    {complex(-0.1, 0.1), 50, 2, 50, 50, false},
+    // -2 goes to 2 and stays there, exactly on the radius
+    {-2, 50, 2, 50, 50, false},
+    // 1 goes to 2, exactly on the radius, then 5
+    {1, 50, 2, 1, smooth(1, 5, 2), true},
+    {1, 1, 2, 1, 1, false},
+    {2, 50, 2, 0, smooth(0, 6, 2), true},
+    // 4 goes to 20 then 404, so escapes a step sooner just under 20
+    {4, 50, 20, 1, smooth(1, 404, 20), true},
+    {4, 50, math.Nextafter(20, 0), 0, smooth(0, 20, math.Nextafter(20, 0)), true},
+    {complex(0, 2), 50, 2, 0, smooth(0, math.Sqrt(20), 2), true},
+  } {
+    iter, smooth, escaped := EscapeTime(c.c, c.maxIter, c.radius)
+    if iter != c.iter || math.Abs(smooth - c.smooth) > 1e-12 || escaped != c.escaped {
+      t.Errorf("EscapeTime(%v, %d, %g) = %d, %g, %v; want %d, %g, %v", c.c, c.maxIter, c.radius,
+        iter, smooth, escaped, c.iter, c.smooth, c.escaped)
+    }
+  }
+}
+
+// Averaged in linear light, a color fading out over transparency stays that
+// color at the blocks' mean coverage, rather than darkening toward the
+// transparent end
+func TestLinearDownScaleNoFringe(t *testing.T) {
+  orange := color.RGBA{255, 128, 0, 255}
+  in := mkImg(16, 2)
+  for y := 0; y < 2; y++ {
+    for x := 0; x < 16; x++ {
+      a := float64(x) * 17
+      in.set(x, y, color.RGBA{uint8(math.Round(a)), uint8(math.Round(128 * a / 255)), 0, uint8(a)})
+    }
+  }
+  for _, scale := range []int{2, 4, 16} {
+    s := boxScaler{in, scale, 2, 0, 0, true}
+    out := make([]color.RGBA, 16 / scale)
+    s.row(0, out)
+    for x, got := range out {
+      a := float64(got.A)
+      if want := 17 * (float64(x * scale) + float64(scale - 1) / 2); math.Abs(a - want) > 1 {
+        t.Errorf("scale %d, pixel %d: alpha %d, want %.1f", scale, x, got.A, want)
+      }
+      for k, v := range [3]uint8{got.R, got.G, got.B} {
+        want := float64([3]uint8{orange.R, orange.G, orange.B}[k]) * a / 255
+        if math.Abs(float64(v) - want) > 2 {
+          t.Errorf("scale %d, pixel %d is %v, want %v at alpha %d", scale, x, got, orange, got.A)
+          break
+        }
+      }
+    }
+  }
+}
+
+// Renders, and the iteration counts they keep, are the same bytes with any
+// number of workers, including the buddhabrot's shared accumulation
+func TestWorkersByteIdentical(t *testing.T) {
+  for _, args := range [][]string{
+    nil,
+    {"-aa", "smart"},
+    {"-filter", "lanczos"},
+    {"-chunk-order", "center"},
+    {"-fractal", "buddhabrot", "-samples", "100000"},
+  } {
+    var px []color.RGBA
+    var iter []int32
+    for _, workers := range []int{1, 2, 7} {
+      cfg := testConfig(t, args...)
+      cfg.Workers = workers
+      if cfg.kernel() != nil {
+        cfg.Escapes = NewEscapeMap(cfg.Cols, cfg.Rows)
+      }
+      got := testRender(t, cfg)
+      if px == nil {
+        px = got
+        if cfg.Escapes != nil {
+          iter = cfg.Escapes.Iter
+        }
+        continue
+      }
+      if !slices.Equal(got, px) {
+        t.Errorf("%v: pixels at %d workers differ from 1", args, workers)
+      }
+      if cfg.Escapes != nil && !slices.Equal(cfg.Escapes.Iter, iter) {
+        t.Errorf("%v: iterations at %d workers differ from 1", args, workers)
+      }
+    }
+  }
+}
+
+// Cancelled partway, a render stops within moments, returning the context's
+// error, with none of its goroutines left running
+func TestRenderContextCancel(t *testing.T) {
+  cfg := testConfig(t, "-iterations", "20000", "-bounds", "-0.75,-0.74,0.1,0.11", "-scale", "6")
+  cfg.Cols, cfg.Rows = 600, 400
+  cfg.Workers = 4
+  before := runtime.NumGoroutine()
+  ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+  defer cancel()
+  start := time.Now()
+  _, err := RenderContext(ctx, cfg)
+  if elapsed := time.Since(start); elapsed > time.Second {
+    t.Errorf("render took %v to stop", elapsed)
+  }
+  if err != context.DeadlineExceeded {
+    t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+  }
+  if n := runtime.NumGoroutine(); n > before {
+    t.Errorf("%d goroutines running after the render, %d before", n, before)
+  }
+}
+
+// Crops of a supersampled image downscaled on its own box grid join side by
+// side into exactly the whole image downscaled, whether they meet on a box
+// edge or overlap by part of one
+func TestDownScaleFromJoins(t *testing.T) {
+  const scale = 3
+  m := image.NewRGBA(image.Rect(0, 0, 10 * scale, 6 * scale))
+  for y := 0; y < m.Bounds().Dy(); y++ {
+    for x := 0; x < m.Bounds().Dx(); x++ {
+      m.SetRGBA(x, y, color.RGBA{uint8(x * 37 + y * 11), uint8(x * y), uint8(y * 53), 255})
+    }
+  }
+  whole, err := DownScale(m, scale, scale)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, c := range []struct {
+    name string
+    crops []image.Rectangle
+    partial bool
+  }{
+    // Meeting on box edges, so nothing is cut off either side
+    {"aligned", []image.Rectangle{image.Rect(0, 0, 12, 18), image.Rect(12, 0, 30, 9), image.Rect(12, 9, 30, 18)}, false},
+    {"aligned, partial", []image.Rectangle{image.Rect(0, 0, 12, 18), image.Rect(12, 0, 30, 9), image.Rect(12, 9, 30, 18)}, true},
+    // Overlapping by less than a box: each drops the box it has only part
+    // of, which the other has whole
+    {"overlapping", []image.Rectangle{image.Rect(0, 0, 14, 18), image.Rect(10, 0, 30, 10), image.Rect(10, 8, 30, 18)}, false},
+  } {
+    joined := image.NewRGBA(whole.Bounds())
+    covered := map[image.Point]int{}
+    for _, r := range c.crops {
+      out, err := DownScaleFrom(m.SubImage(r), scale, scale, image.Point{}, c.partial)
+      if err != nil {
+        t.Fatalf("%s: crop %v: %v", c.name, r, err)
+      }
+      // The first box kept is the one at or just inside the crop's corner
+      at := image.Pt((r.Min.X + scale - 1) / scale, (r.Min.Y + scale - 1) / scale)
+      for y := 0; y < out.Bounds().Dy(); y++ {
+        for x := 0; x < out.Bounds().Dx(); x++ {
+          joined.SetRGBA(at.X + x, at.Y + y, out.RGBAAt(x, y))
+          covered[at.Add(image.Pt(x, y))]++
+        }
+      }
+    }
+    for y := 0; y < whole.Bounds().Dy(); y++ {
+      for x := 0; x < whole.Bounds().Dx(); x++ {
+        if n := covered[image.Pt(x, y)]; n != 1 {
+          t.Errorf("%s: pixel %d,%d is in %d crops, want 1", c.name, x, y, n)
+        } else if got, want := joined.RGBAAt(x, y), whole.RGBAAt(x, y); got != want {
+          t.Errorf("%s: pixel %d,%d is %v, want %v", c.name, x, y, got, want)
+        }
+      }
+    }
+  }
+}
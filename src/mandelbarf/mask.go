@@ -0,0 +1,52 @@
+package main
+
+import (
+  "image"
+  "image/color"
+  "image/png"
+  "math"
+  "os"
+)
+
+const maskMinIter = 0.25 // Fraction of the iteration cap a black pixel of a mask gets
+
+// Read a PNG for -mask
+func loadMask(fileName string) (image.Image, error) {
+  file, err := os.Open(fileName)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+  return png.Decode(file)
+}
+
+// The effort cfg's mask asks for at each output pixel, row-major: the
+// luminance, 0 for black to 1 for white, of the mask pixel under the
+// pixel's center, the mask being stretched over the output. Nil without a
+// mask.
+func (cfg RenderConfig) maskEffort() []float64 {
+  if cfg.Mask == nil {
+    return nil
+  }
+  b := cfg.Mask.Bounds()
+  effort := make([]float64, cfg.Cols * cfg.Rows)
+  for y := 0; y < cfg.Rows; y++ {
+    my := b.Min.Y + (2 * y + 1) * b.Dy() / (2 * cfg.Rows)
+    for x := 0; x < cfg.Cols; x++ {
+      mx := b.Min.X + (2 * x + 1) * b.Dx() / (2 * cfg.Cols)
+      g := color.Gray16Model.Convert(cfg.Mask.At(mx, my)).(color.Gray16)
+      effort[y * cfg.Cols + x] = float64(g.Y) / 0xffff
+    }
+  }
+  return effort
+}
+
+// How to spend effort e of maskEffort on a pixel sampled sx by sy times at
+// full effort: with samples from 1 by 1 at 0 to sx by sy at 1, and the
+// iteration cap from maskMinIter of cfg's at 0 to all of it at 1
+func (cfg RenderConfig) maskBudget(e float64, sx, sy int) (nx, ny, maxIter int) {
+  nx = 1 + int(math.Round(e * float64(sx - 1)))
+  ny = 1 + int(math.Round(e * float64(sy - 1)))
+  maxIter = max(1, int(math.Round(float64(cfg.Iterations) * (maskMinIter + (1 - maskMinIter) * e))))
+  return nx, ny, maxIter
+}
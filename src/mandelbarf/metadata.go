@@ -0,0 +1,54 @@
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "encoding/json"
+  "fmt"
+  "os"
+)
+
+// Rendered PNGs carry their RenderConfig, as JSON in a tEXt chunk under this
+// keyword, so -continue can carry on from them
+const configKeyword = "mandelbarf"
+
+// A tEXt chunk holding cfg
+func configChunk(cfg RenderConfig) ([]byte, error) {
+  text, err := json.Marshal(cfg)
+  if err != nil {
+    return nil, err
+  }
+  var chunk bytes.Buffer
+  writeChunk(&chunk, "tEXt", append([]byte(configKeyword + "\x00"), text...))
+  return chunk.Bytes(), nil
+}
+
+// Read the RenderConfig embedded in the PNG file fileName
+func readPNGConfig(fileName string) (RenderConfig, error) {
+  data, err := os.ReadFile(fileName)
+  if err != nil {
+    return RenderConfig{}, err
+  }
+  if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+    return RenderConfig{}, fmt.Errorf("%s isn't a PNG", fileName)
+  }
+  data = data[8:]
+  prefix := []byte(configKeyword + "\x00")
+  // Each chunk is its length, type, data and CRC
+  for len(data) >= 12 {
+    n := binary.BigEndian.Uint32(data)
+    if uint64(n) > uint64(len(data) - 12) {
+      break
+    }
+    kind, body := string(data[4:8]), data[8:8 + n]
+    data = data[12 + n:]
+    if kind == "tEXt" && bytes.HasPrefix(body, prefix) {
+      var cfg RenderConfig
+      if err := json.Unmarshal(body[len(prefix):], &cfg); err != nil {
+        return RenderConfig{}, fmt.Errorf("%s has malformed settings: %v", fileName, err)
+      }
+      return cfg, nil
+    }
+  }
+  return RenderConfig{}, fmt.Errorf("%s has no mandelbarf settings", fileName)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func mkImgMapped(cols, rows int, fileName string) (img, func() error, error) {
+  return img{}, nil, errors.New("-mmap is only supported on unix")
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package main
+
+import (
+  "image/color"
+  "os"
+  "syscall"
+  "unsafe"
+)
+
+// Make an img whose pixels live in a memory-mapped file, so the OS can page
+// them out. The file is removed as soon as it's mapped, so it won't outlive
+// the process even on a crash. The returned function unmaps it.
+func mkImgMapped(cols, rows int, fileName string) (img, func() error, error) {
+  size := cols * rows * int(unsafe.Sizeof(color.RGBA{}))
+  file, err := os.OpenFile(fileName, os.O_RDWR | os.O_CREATE | os.O_EXCL, 0600)
+  if err != nil {
+    return img{}, nil, err
+  }
+  defer file.Close()
+  defer os.Remove(fileName)
+
+  if err := file.Truncate(int64(size)); err != nil {
+    return img{}, nil, err
+  }
+  data, err := syscall.Mmap(int(file.Fd()), 0, size,
+    syscall.PROT_READ | syscall.PROT_WRITE, syscall.MAP_SHARED)
+  if err != nil {
+    return img{}, nil, err
+  }
+  px := unsafe.Slice((*color.RGBA)(unsafe.Pointer(&data[0])), cols * rows)
+  unmap := func() error { return syscall.Munmap(data) }
+  return img{cols, rows, px}, unmap, nil
+}
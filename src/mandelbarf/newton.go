@@ -0,0 +1,62 @@
+package main
+
+import (
+  "image/color"
+  "math"
+  "math/cmplx"
+)
+
+const newtonTolerance = 1e-6 // Treat z as converged within this of a root
+
+// Find a root of z^degree - 1 by Newton's method starting from z. Return the
+// root's index (counting counterclockwise from 1) and the number of steps
+// taken, or -1 if it didn't converge within maxIter steps.
+func newton(z complex128, degree, maxIter int) (root, steps int) {
+  n := complex(float64(degree), 0)
+  for steps = 0; steps < maxIter; steps++ {
+    zn1 := cmplx.Pow(z, n - 1) // z^(degree-1)
+    if zn1 == 0 {
+      break
+    }
+    z -= (zn1 * z - 1) / (n * zn1)
+    if math.Abs(cmplx.Abs(z) - 1) < newtonTolerance {
+      k := int(math.Round(cmplx.Phase(z) * float64(degree) / (2 * math.Pi)))
+      k = (k + degree) % degree
+      if cmplx.Abs(z - cmplx.Rect(1, 2 * math.Pi * float64(k) / float64(degree))) < newtonTolerance {
+        return k, steps
+      }
+    }
+  }
+  return -1, steps
+}
+
+// One color per root, evenly spaced along the palette
+func rootColors(cfg RenderConfig) []color.RGBA {
+  colors := make([]color.RGBA, cfg.Degree)
+  for k := range colors {
+    colors[k] = cfg.Palette.at(float64(k + 1) / float64(cfg.Degree), cfg.PaletteSpace)
+  }
+  return colors
+}
+
+// Color a point by the root it converges to, darker the longer it takes
+func newtonColor(cfg RenderConfig, roots []color.RGBA, c complex128) color.RGBA {
+  root, steps := newton(c, cfg.Degree, cfg.Iterations)
+  if root < 0 {
+    if cfg.Alpha {
+      return color.RGBA{0, 0, 0, 0}
+    }
+    if cfg.Inside.A != 0 {
+      return cfg.Inside
+    }
+    return color.RGBA{0, 0, 0, 255}
+  }
+  shade := math.Pow(0.9, float64(steps))
+  rc := roots[root]
+  return color.RGBA{
+    uint8(float64(rc.R) * shade),
+    uint8(float64(rc.G) * shade),
+    uint8(float64(rc.B) * shade),
+    255,
+  }
+}
@@ -0,0 +1,19 @@
+package main
+
+import "image/draw"
+
+// An Overlay draws annotations, like a grid, scale bar or caption, on a
+// finished render. Overlays draw on the output after it's downsampled, never
+// on the supersampled image, where they'd be blurred along with the
+// fractal. cfg.PixelToComplex and cfg.ComplexToPixel map between m's pixels
+// and the complex plane.
+type Overlay interface {
+  Draw(m draw.Image, cfg RenderConfig)
+}
+
+// Draw cfg's overlays on its output m, in order
+func drawOverlays(m draw.Image, cfg RenderConfig) {
+  for _, o := range cfg.Overlays {
+    o.Draw(m, cfg)
+  }
+}
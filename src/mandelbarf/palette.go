@@ -0,0 +1,254 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "image/color"
+  "math"
+  "os"
+  "strconv"
+  "strings"
+)
+
+// A Palette is a gradient through evenly spaced color stops
+type Palette []color.RGBA
+
+var palettes = map[string]Palette{
+  "cyan": {{0, 0, 0, 255}, {0, 255, 255, 255}},
+  "gray": {{0, 0, 0, 255}, {255, 255, 255, 255}},
+  "fire": {
+    {0, 0, 0, 255}, {128, 0, 0, 255}, {255, 96, 0, 255},
+    {255, 208, 64, 255}, {255, 255, 224, 255},
+  },
+  "ocean": {
+    {0, 0, 32, 255}, {0, 48, 128, 255}, {0, 160, 192, 255},
+    {224, 255, 255, 255},
+  },
+}
+
+// Read a palette from a file with one hex color (rrggbb) per line
+func loadGradient(fileName string) (Palette, error) {
+  file, err := os.Open(fileName)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  var g Palette
+  scanner := bufio.NewScanner(file)
+  for line := 1; scanner.Scan(); line++ {
+    text := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "#")
+    if text == "" {
+      continue
+    }
+    v, err := strconv.ParseUint(text, 16, 32)
+    if err != nil || len(text) != 6 {
+      return nil, fmt.Errorf("%s:%d: bad color %q", fileName, line, text)
+    }
+    g = append(g, color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255})
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+  if len(g) < 2 {
+    return nil, fmt.Errorf("%s: need at least 2 colors", fileName)
+  }
+  return g, nil
+}
+
+// Parse rrggbb or, with non-premultiplied alpha, rrggbbaa
+func parseHexColor(text string) (color.RGBA, error) {
+  text = strings.TrimPrefix(text, "#")
+  v, err := strconv.ParseUint(text, 16, 32)
+  if err != nil || (len(text) != 6 && len(text) != 8) {
+    return color.RGBA{}, fmt.Errorf("bad color %q", text)
+  }
+  if len(text) == 6 {
+    v = v << 8 | 0xff
+  }
+  c := color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+  return color.RGBAModel.Convert(c).(color.RGBA), nil
+}
+
+// Color at position t along the palette, from the first stop at 0 to the
+// last at 1, interpolating in sRGB. Outside 0 to 1 it's the nearest end.
+func (g Palette) At(t float64) color.RGBA {
+  return g.at(t, "srgb")
+}
+
+// The palette running from the last stop to the first
+func (g Palette) Reversed() Palette {
+  r := make(Palette, len(g))
+  for i, c := range g {
+    r[len(g) - 1 - i] = c
+  }
+  return r
+}
+
+// The palette run through n times from 0 to 1, each run blending into the
+// next
+func (g Palette) Repeated(n int) Palette {
+  r := make(Palette, 0, len(g) * max(1, n))
+  for i := 0; i < max(1, n); i++ {
+    r = append(r, g...)
+  }
+  return r
+}
+
+// Like At, interpolating in space ("srgb" or "oklab")
+func (g Palette) at(t float64, space string) color.RGBA {
+  t = math.Max(0, math.Min(1, t)) * float64(len(g)-1)
+  i := int(t)
+  if i >= len(g)-1 {
+    return g[len(g)-1]
+  }
+  frac := t - float64(i)
+  a, b := g[i], g[i+1]
+  if space == "oklab" {
+    l1, a1, b1 := rgbToOklab(a)
+    l2, a2, b2 := rgbToOklab(b)
+    return oklabToRGB(lerp(l1, l2, frac), lerp(a1, a2, frac), lerp(b1, b2, frac))
+  }
+  return color.RGBA{
+    lerp8(a.R, b.R, frac),
+    lerp8(a.G, b.G, frac),
+    lerp8(a.B, b.B, frac),
+    255,
+  }
+}
+
+// Sample the palette at n evenly spaced positions
+func (g Palette) table(n int, space string) []color.RGBA {
+  t := make([]color.RGBA, n)
+  for i := range t {
+    t[i] = g.at(float64(i) / float64(n-1), space)
+  }
+  return t
+}
+
+// Ways to map an iteration count (0 to max) to a palette position (0 to 1)
+var colorScales = map[string]func(iter, max float64) float64{
+  "linear": func(iter, max float64) float64 { return iter / max },
+  "log": func(iter, max float64) float64 { return math.Log1p(iter) / math.Log1p(max) },
+  "sqrt": func(iter, max float64) float64 { return math.Sqrt(iter / max) },
+}
+
+// Map an iteration count to a palette position from 0 to 1 by cfg's color
+// scale: shifted by ColorOffset, then over end iterations, or with a
+// ColorPeriod, over each period, wrapping around
+func (cfg RenderConfig) palettePosition(end float64) func(iter float64) float64 {
+  scale := colorScales[cfg.ColorScale]
+  offset, period := cfg.ColorOffset, cfg.ColorPeriod
+  if period > 0 {
+    return func(iter float64) float64 {
+      return scale(math.Mod(math.Mod(iter + offset, period) + period, period), period)
+    }
+  }
+  return func(iter float64) float64 {
+    return scale(math.Max(0, math.Min(end, iter + offset)), end)
+  }
+}
+
+// Look up the color for each of n iteration counts
+func colorTable(cfg RenderConfig, n int) []color.RGBA {
+  scale := colorScales[cfg.ColorScale]
+  t := make([]color.RGBA, n)
+  for i := range t {
+    t[i] = cfg.Palette.at(scale(float64(i), float64(n-1)), cfg.PaletteSpace)
+  }
+  return t
+}
+
+func lerp(x, y, t float64) float64 {
+  return x + (y - x) * t
+}
+
+func lerp8(x, y uint8, t float64) uint8 {
+  return uint8(math.Round(lerp(float64(x), float64(y), t)))
+}
+
+// Color space conversions
+
+// sRGB component (0 to 255) to linear light (0 to 1)
+func srgbToLinear(c uint8) float64 {
+  return decodeSRGB(float64(c) / 255)
+}
+
+// Linear light (0 to 1) to sRGB component (0 to 255), clamping
+func linearToSRGB(v float64) uint8 {
+  return uint8(math.Round(encodeSRGB(math.Max(0, math.Min(1, v))) * 255))
+}
+
+// The sRGB transfer function and its inverse, on values from 0 to 1
+func decodeSRGB(v float64) float64 {
+  if v <= 0.04045 {
+    return v / 12.92
+  }
+  return math.Pow((v + 0.055) / 1.055, 2.4)
+}
+
+func encodeSRGB(v float64) float64 {
+  if v <= 0.0031308 {
+    return v * 12.92
+  }
+  return 1.055 * math.Pow(v, 1 / 2.4) - 0.055
+}
+
+// The channels of c, which is premultiplied sRGB, for a weighted sum of
+// colors. In linear light, the color is unpremultiplied, decoded, and
+// premultiplied again, so samples add light in proportion to their
+// coverage, and transparent ones add nothing: averaging then neither darkens
+// bright detail nor fringes translucent edges.
+func channels(c color.RGBA, linear bool) [4]float64 {
+  if !linear {
+    return [4]float64{float64(c.R), float64(c.G), float64(c.B), float64(c.A)}
+  }
+  if c.A == 0 {
+    return [4]float64{}
+  }
+  a := float64(c.A)
+  lin := func(v uint8) float64 {
+    return decodeSRGB(math.Min(1, float64(v) / a)) * a
+  }
+  return [4]float64{lin(c.R), lin(c.G), lin(c.B), a}
+}
+
+// A weighted sum of channels, normalized, back to a color, clamped valid
+func fromChannels(sum [4]float64, linear bool) color.RGBA {
+  if !linear {
+    return clampPremultiplied(sum)
+  }
+  alpha := math.Round(math.Max(0, math.Min(255, sum[3])))
+  if alpha == 0 {
+    return color.RGBA{}
+  }
+  enc := func(v float64) uint8 {
+    return uint8(math.Round(encodeSRGB(math.Max(0, math.Min(1, v / sum[3]))) * alpha))
+  }
+  return color.RGBA{enc(sum[0]), enc(sum[1]), enc(sum[2]), uint8(alpha)}
+}
+
+// See https://bottosson.github.io/posts/oklab/
+func rgbToOklab(c color.RGBA) (l, a, b float64) {
+  r, g, bl := srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)
+  lc := math.Cbrt(0.4122214708 * r + 0.5363325363 * g + 0.0514459929 * bl)
+  mc := math.Cbrt(0.2119034982 * r + 0.6806995451 * g + 0.1073969566 * bl)
+  sc := math.Cbrt(0.0883024619 * r + 0.2817188376 * g + 0.6299787005 * bl)
+  l = 0.2104542553 * lc + 0.7936177850 * mc - 0.0040720468 * sc
+  a = 1.9779984951 * lc - 2.4285922050 * mc + 0.4505937099 * sc
+  b = 0.0259040371 * lc + 0.7827717662 * mc - 0.8086757660 * sc
+  return
+}
+
+func oklabToRGB(l, a, b float64) color.RGBA {
+  lc := l + 0.3963377774 * a + 0.2158037573 * b
+  mc := l - 0.1055613458 * a - 0.0638541728 * b
+  sc := l - 0.0894841775 * a - 1.2914855480 * b
+  lc, mc, sc = lc * lc * lc, mc * mc * mc, sc * sc * sc
+  return color.RGBA{
+    linearToSRGB(4.0767416621 * lc - 3.3077115913 * mc + 0.2309699292 * sc),
+    linearToSRGB(-1.2684380046 * lc + 2.6097574011 * mc - 0.3413193965 * sc),
+    linearToSRGB(-0.0041960863 * lc - 0.7034186147 * mc + 1.7076147010 * sc),
+    255,
+  }
+}
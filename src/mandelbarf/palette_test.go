@@ -0,0 +1,71 @@
+package main
+
+import (
+  "image/color"
+  "math"
+  "slices"
+  "testing"
+)
+
+func TestPaletteAt(t *testing.T) {
+  fire := palettes["fire"]
+  black, red := color.RGBA{0, 0, 0, 255}, color.RGBA{128, 0, 0, 255}
+  cream := color.RGBA{255, 255, 224, 255}
+  for _, c := range []struct {
+    t float64
+    want color.RGBA
+  }{
+    // On the stops, at 0, 1/4, ... 1
+    {0, black},
+    {0.25, red},
+    {0.5, fire[2]},
+    {1, cream},
+    // Halfway between the first two, and just either side of the second
+    {0.125, color.RGBA{64, 0, 0, 255}},
+    {math.Nextafter(0.25, 0), red},
+    {math.Nextafter(0.25, 1), red},
+    // Outside 0 to 1, the nearest end
+    {-0.5, black},
+    {math.Inf(-1), black},
+    {1.5, cream},
+    {math.Inf(1), cream},
+  } {
+    if got := fire.At(c.t); got != c.want {
+      t.Errorf("At(%g) = %v, want %v", c.t, got, c.want)
+    }
+  }
+}
+
+func TestPaletteReversedRepeated(t *testing.T) {
+  fire := palettes["fire"]
+  reversed := fire.Reversed()
+  for _, pos := range []float64{0, 0.1, 0.25, 0.3, 0.5, 0.9, 1} {
+    if got, want := reversed.At(pos), fire.At(1 - pos); got != want {
+      t.Errorf("Reversed().At(%g) = %v, want At(%g) = %v", pos, got, 1 - pos, want)
+    }
+  }
+  if !slices.Equal(reversed.Reversed(), fire) {
+    t.Error("reversing twice doesn't give back the palette")
+  }
+
+  // Run through twice, cyan's black to cyan stops at 0, 1/3, 2/3, and 1
+  cyan := palettes["cyan"]
+  twice := cyan.Repeated(2)
+  for _, c := range []struct {
+    t float64
+    want color.RGBA
+  }{
+    {0, cyan[0]},
+    {1.0 / 3, cyan[1]},
+    {2.0 / 3, cyan[0]},
+    {1, cyan[1]},
+    {0.5, cyan.At(0.5)}, // Blending from the first run's end into the second's start
+  } {
+    if got := twice.At(c.t); got != c.want {
+      t.Errorf("Repeated(2).At(%g) = %v, want %v", c.t, got, c.want)
+    }
+  }
+  if len(cyan.Repeated(0)) != len(cyan) {
+    t.Errorf("Repeated(0) has %d stops, want %d", len(cyan.Repeated(0)), len(cyan))
+  }
+}
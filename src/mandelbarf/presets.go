@@ -0,0 +1,76 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "os"
+  "sort"
+)
+
+// A named view of the mandelbrot set, centered on a point and width wide
+type preset struct {
+  center complex128
+  width float64
+  iterations int
+  about string
+}
+
+// Misiurewicz points: values of c where the orbit of 0 never escapes and is
+// never periodic, but lands on a cycle after finitely many steps. The set
+// is asymptotically self-similar about each one, looking like the Julia set
+// for that c, so zooming in keeps showing the same spiral or branching
+// shape. They lie on the boundary, so every nearby pixel is slow to escape
+// and they need far more iterations than autoIterations would pick for the
+// zoom.
+var presets = map[string]preset{
+  "antenna": {-2, 0.05, 1024,
+    "c = -2, the tip of the antenna: 0 -> -2 -> 2, a fixed point. " +
+    "The leftmost point of the set, where it thins to a bare line segment"},
+  "real-branch": {-1.5436890126920764, 0.002, 2048,
+    "c ~ -1.54369, on the real axis: reaches a fixed point after 3 steps. " +
+    "Three branches meet here, repeating at every scale"},
+  "dendrite": {1i, 0.05, 2048,
+    "c = i: 0 -> i -> -1+i -> -i -> -1+i, a 2-cycle. " +
+    "Its Julia set is a dendrite, with no interior, and the set here looks the same"},
+  "branch-point": {-0.10109636384562219 + 0.9562865108091415i, 0.01, 2048,
+    "c ~ -0.10110+0.95629i: reaches a fixed point after 4 steps. " +
+    "Three arms meet, each splitting into three again as you zoom"},
+  "spiral": {-0.7756837680090538 + 0.1364673682946901i, 0.0001, 4096,
+    "c ~ -0.77568+0.13647i, in seahorse valley: lands on a 2-cycle after 24 steps, " +
+    "so two spiral arms wind into it, repeating at every scale"},
+}
+
+// Set cfg's bounds to frame the named preset at the aspect ratio of the image
+func (cfg *RenderConfig) applyPreset(name string) error {
+  p, ok := presets[name]
+  if !ok {
+    return fmt.Errorf("unknown preset %q", name)
+  }
+  if cfg.Fractal != "mandelbrot" {
+    return fmt.Errorf("preset %q is a view of the mandelbrot set, not %s", name, cfg.Fractal)
+  }
+  RegionAt(p.center, p.width, cfg.Cols, cfg.Rows).Apply(cfg)
+  return nil
+}
+
+// Print the presets, in name order
+func listPresets(w io.Writer) {
+  names := make([]string, 0, len(presets))
+  for name := range presets {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    p := presets[name]
+    fmt.Fprintf(w, "%-13s width %g, %d iterations\n  %s\n", name, p.width, p.iterations, p.about)
+  }
+}
+
+// List the presets usable with -preset
+func presetsCmd(args []string) error {
+  if len(args) > 0 {
+    return fmt.Errorf("presets: unexpected arguments %q", args)
+  }
+  listPresets(os.Stdout)
+  return nil
+}
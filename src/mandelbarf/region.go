@@ -0,0 +1,79 @@
+package main
+
+// A view of the plane: the rectangle Width by Height about Center, over a
+// grid of Cols by Rows output pixels, which render as the config it's
+// applied to maps them. The methods return a new Region, leaving r alone.
+type Region struct {
+  Center complex128
+  Width, Height float64
+  Cols, Rows int
+}
+
+// The region cfg's bounds cover, over its output pixels
+func RegionOf(cfg RenderConfig) Region {
+  return Region{
+    Center: complex((cfg.XMin + cfg.XMax) / 2, (cfg.YMin + cfg.YMax) / 2),
+    Width: cfg.XMax - cfg.XMin,
+    Height: cfg.YMax - cfg.YMin,
+    Cols: cfg.Cols,
+    Rows: cfg.Rows,
+  }
+}
+
+// The region width wide about center, over cols by rows pixels, its height
+// set by the aspect ratio of the pixels
+func RegionAt(center complex128, width float64, cols, rows int) Region {
+  return Region{Center: center, Width: width, Cols: cols, Rows: rows}.Fit(cols, rows)
+}
+
+// The bounds of r
+func (r Region) Bounds() (xMin, xMax, yMin, yMax float64) {
+  re, im := real(r.Center), imag(r.Center)
+  return re - r.Width / 2, re + r.Width / 2, im - r.Height / 2, im + r.Height / 2
+}
+
+// Set cfg's bounds and size to r's
+func (r Region) Apply(cfg *RenderConfig) {
+  cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax = r.Bounds()
+  cfg.Cols, cfg.Rows = r.Cols, r.Rows
+}
+
+// r over cols by rows pixels, keeping its center and width and setting its
+// height by their aspect ratio
+func (r Region) Fit(cols, rows int) Region {
+  r.Cols, r.Rows = cols, rows
+  r.Height = r.Width * float64(rows) / float64(cols)
+  return r
+}
+
+// r magnified factor times about its center
+func (r Region) ZoomIn(factor float64) Region {
+  r.Width, r.Height = r.Width / factor, r.Height / factor
+  return r
+}
+
+// r shrunk factor times about its center
+func (r Region) ZoomOut(factor float64) Region {
+  r.Width, r.Height = r.Width * factor, r.Height * factor
+  return r
+}
+
+// r moved by dre,dim in the plane
+func (r Region) PanBy(dre, dim float64) Region {
+  r.Center += complex(dre, dim)
+  return r
+}
+
+// The point of the plane at the center of output pixel x,y, rendering r with
+// cfg's other settings, as PixelToComplex gives it
+func (r Region) Point(cfg RenderConfig, x, y int) complex128 {
+  r.Apply(&cfg)
+  return cfg.PixelToComplex(x, y)
+}
+
+// r centered on the point at output pixel x,y, rendering with cfg, as
+// zooming toward a click does before ZoomIn
+func (r Region) Focus(cfg RenderConfig, pixelX, pixelY int) Region {
+  r.Center = r.Point(cfg, pixelX, pixelY)
+  return r
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+  "math/cmplx"
+  "testing"
+)
+
+// Near enough, for points a few operations apart
+func near(a, b complex128) bool {
+  return cmplx.Abs(a - b) <= 1e-12 * max(1, cmplx.Abs(a))
+}
+
+// Pixels map as PixelToComplex maps them once the region is applied, the
+// middle one to the center, and Apply and RegionOf undo each other
+func TestRegionPoint(t *testing.T) {
+  r := RegionAt(complex(-0.5, 0.25), 3, 61, 41)
+  if r.Height != 3 * 41.0 / 61 {
+    t.Errorf("height %g, want %g", r.Height, 3 * 41.0 / 61)
+  }
+  cfg := testConfig(t)
+  r.Apply(&cfg)
+  if got := r.Point(cfg, 30, 20); !near(got, r.Center) {
+    t.Errorf("Point(30, 20) = %v, want the center %v", got, r.Center)
+  }
+  for _, px := range [][2]int{{0, 0}, {60, 40}, {30, 20}, {12, 33}} {
+    if got, want := r.Point(cfg, px[0], px[1]), cfg.PixelToComplex(px[0], px[1]); got != want {
+      t.Errorf("Point(%d, %d) = %v, but PixelToComplex gives %v", px[0], px[1], got, want)
+    }
+  }
+  // At -scale 2 the first pixel's center is half a sample in from the corner
+  xMin, xMax, yMin, yMax := r.Bounds()
+  dx, dy := (xMax - xMin) / (2 * 61 - 1), (yMax - yMin) / (2 * 41 - 1)
+  if got, want := r.Point(cfg, 0, 0), complex(xMin + dx / 2, yMax - dy / 2); !near(got, want) {
+    t.Errorf("Point(0, 0) = %v, want %v", got, want)
+  }
+  if back := RegionOf(cfg); !near(back.Center, r.Center) || back.Cols != r.Cols || back.Rows != r.Rows {
+    t.Errorf("RegionOf after Apply gives %+v, want %+v", back, r)
+  }
+}
+
+// Zooming on a clicked pixel centers the view on the point under it, and
+// shrinks the view about it; zooming out again undoes it
+func TestRegionZoomOnPixel(t *testing.T) {
+  r := RegionAt(complex(-0.5, 0), 3, 61, 41)
+  cfg := testConfig(t)
+  for _, click := range [][2]int{{30, 20}, {0, 0}, {60, 40}, {12, 33}} {
+    clicked := r.Point(cfg, click[0], click[1])
+    z := r.Focus(cfg, click[0], click[1]).ZoomIn(4)
+    if !near(z.Center, clicked) {
+      t.Errorf("zooming on %v centers on %v, want %v", click, z.Center, clicked)
+    }
+    if got := z.Point(cfg, 30, 20); !near(got, clicked) {
+      t.Errorf("zooming on %v puts %v at the middle pixel, want %v", click, got, clicked)
+    }
+    if z.Width != r.Width / 4 || z.Height != r.Height / 4 || z.Cols != r.Cols || z.Rows != r.Rows {
+      t.Errorf("zooming on %v gives %gx%g over %dx%d, want %gx%g over %dx%d", click,
+        z.Width, z.Height, z.Cols, z.Rows, r.Width / 4, r.Height / 4, r.Cols, r.Rows)
+    }
+    if out := z.ZoomOut(4); out.Width != r.Width || out.Height != r.Height {
+      t.Errorf("zooming out again gives %gx%g, want %gx%g", out.Width, out.Height, r.Width, r.Height)
+    }
+  }
+
+  // Panning moves every pixel by the same amount
+  p := r.PanBy(0.25, -1)
+  for _, px := range [][2]int{{0, 0}, {17, 9}, {60, 40}} {
+    if got, want := p.Point(cfg, px[0], px[1]), r.Point(cfg, px[0], px[1]) + complex(0.25, -1); !near(got, want) {
+      t.Errorf("panned, pixel %v is at %v, want %v", px, got, want)
+    }
+  }
+  if r.Center != complex(-0.5, 0) {
+    t.Errorf("the methods changed the region they were called on, centering it on %v", r.Center)
+  }
+}
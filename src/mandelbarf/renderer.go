@@ -0,0 +1,89 @@
+package main
+
+import (
+  "context"
+  "image"
+  "image/color"
+  "sync/atomic"
+  "time"
+)
+
+// How far along a render is: Rows of Total output rows are done, from the
+// top, Elapsed after it started
+type Progress struct {
+  Rows, Total int
+  Elapsed time.Duration
+}
+
+// A render of Config, to run in the background while its progress is
+// watched. Make one with NewRenderer, call Start exactly once, and then
+// Result, which waits for the render to finish. Start's channel and Result
+// may be used from any goroutine; Config must not change once it's
+// started.
+type Renderer struct {
+  Config RenderConfig
+  started atomic.Bool
+  done chan struct{} // Closed once m and err are set
+  m image.Image
+  err error
+}
+
+func NewRenderer(cfg RenderConfig) *Renderer {
+  return &Renderer{Config: cfg, done: make(chan struct{})}
+}
+
+// Start rendering, giving up if ctx is done first. The returned channel
+// gets a Progress as each output row is done, and is closed once Result is
+// ready. It has room for every row, so leaving it unread never holds up the
+// render. Smart AA and the buddhabrot finish all their rows at once, at the
+// end. Starting a Renderer twice panics.
+func (r *Renderer) Start(ctx context.Context) <-chan Progress {
+  if r.started.Swap(true) {
+    panic("Renderer started twice")
+  }
+  progress := make(chan Progress, max(0, r.Config.Rows))
+  go func() {
+    defer close(progress)
+    start := time.Now()
+    m, err := renderProgress(ctx, r.Config, func(rows int) {
+      progress <- Progress{rows, r.Config.Rows, time.Since(start)}
+    })
+    if err != nil && ctx.Err() != nil {
+      err = ctx.Err()
+    }
+    if err == nil {
+      r.m = m
+    }
+    r.err = err
+    close(r.done)
+  }()
+  return progress
+}
+
+// Wait for the render Start began to finish, returning its image or why it
+// failed: ctx's error if it was done first, and otherwise a *RenderError
+func (r *Renderer) Result() (image.Image, error) {
+  <- r.done
+  return r.m, r.err
+}
+
+// Like renderContext, calling progress with how many rows from the top are
+// done after each
+func renderProgress(ctx context.Context, cfg RenderConfig, progress func(rows int)) (img, error) {
+  // Before making the image, which a bad size would panic
+  if err := cfg.validate(); err != nil {
+    return img{}, &RenderError{cfg, err}
+  }
+  out := mkImg(cfg.Cols, cfg.Rows)
+  err := renderStream(ctx, cfg, func(row int, pixels []color.RGBA) {
+    copy(out.px[row * out.cols:], pixels)
+    if progress != nil {
+      progress(row + 1)
+    }
+  })
+  if err != nil {
+    return img{}, err
+  }
+  drawOverlays(out, cfg)
+  return out, nil
+}
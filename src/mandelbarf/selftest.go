@@ -0,0 +1,113 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+)
+
+const selftestIter = 1000 // Iteration cap of the selftest checks
+const selftestGrid = 200 // Points a side of the grids the kernels are compared on
+
+// Invariants selftest checks, each returning an error describing the first
+// mismatch it finds
+var selftestChecks = []struct {
+  name string
+  check func() error
+}{
+  {"0 is in the set", func() error {
+    if _, _, escaped := EscapeTime(0, selftestIter, escapeThresh); escaped {
+      return errors.New("0 escaped")
+    }
+    return nil
+  }},
+  {"1 escapes", func() error {
+    iter, _, escaped := EscapeTime(1, selftestIter, escapeThresh)
+    if !escaped {
+      return errors.New("1 never escaped")
+    }
+    if iter != 3 {
+      return fmt.Errorf("1 took %d iterations to pass %g, not 3", iter, escapeThresh)
+    }
+    return nil
+  }},
+  {"fast loop agrees with the generic one", func() error {
+    return compareKernels(func(c complex128) (int, complex128) {
+      return mandelbrot(c, selftestIter, escapeThresh)
+    }, func(c complex128) (int, complex128) {
+      return escape(mandelbrotFractal{}, c, selftestIter, escapeThresh, norms["l2"])
+    }, true)
+  }},
+  {"farthest-point loop agrees on escapes", func() error {
+    return compareKernels(func(c complex128) (int, complex128) {
+      return escape(mandelbrotFractal{}, c, selftestIter, escapeThresh, norms["l2"])
+    }, func(c complex128) (int, complex128) {
+      return escapeFarthest(mandelbrotFractal{}, c, selftestIter, escapeThresh, norms["l2"])
+    }, false)
+  }},
+  {"double-double agrees with float64", func() error {
+    // Over few enough iterations that float64 hasn't rounded far enough
+    // from the exact orbit to change a count
+    return compareKernels(func(c complex128) (int, complex128) {
+      return mandelbrot(c, 64, escapeThresh)
+    }, func(c complex128) (int, complex128) {
+      return mandelbrotDD(dd{real(c), 0}, dd{imag(c), 0}, 64, escapeThresh, norms["l2"], false)
+    }, false)
+  }},
+  {"smooth value falls along rays leaving the set", func() error {
+    // From just outside the cusp at 1/4 and the tip of the antenna at -2
+    for _, ray := range [][2]complex128{{0.2501, 2}, {-2.0001, -4}} {
+      prev := 0.0
+      for i := 0; i <= 10000; i++ {
+        c := ray[0] + (ray[1] - ray[0]) * complex(float64(i) / 10000, 0)
+        _, smooth, escaped := EscapeTime(c, selftestIter, escapeThresh)
+        if !escaped {
+          return fmt.Errorf("%v never escaped", c)
+        }
+        if i > 0 && smooth > prev + 1e-9 {
+          return fmt.Errorf("smooth value rose from %g to %g at %v", prev, smooth, c)
+        }
+        prev = smooth
+      }
+    }
+    return nil
+  }},
+}
+
+// Check that a and b give the same iteration count, and if sameZ the same
+// final z, on a grid over the mandelbrot set's default view
+func compareKernels(a, b func(c complex128) (int, complex128), sameZ bool) error {
+  bounds := fractals["mandelbrot"].bounds
+  for y := 0; y < selftestGrid; y++ {
+    for x := 0; x < selftestGrid; x++ {
+      c := complex(lerp(bounds[0], bounds[1], float64(x) / (selftestGrid - 1)),
+        lerp(bounds[2], bounds[3], float64(y) / (selftestGrid - 1)))
+      ia, za := a(c)
+      ib, zb := b(c)
+      if ia != ib || sameZ && za != zb {
+        return fmt.Errorf("at %v: %d iterations ending at %v against %d ending at %v", c, ia, za, ib, zb)
+      }
+    }
+  }
+  return nil
+}
+
+// Check the numerical invariants of selftestChecks, printing how each
+// went, and fail if any doesn't hold
+func selftestCmd(args []string) error {
+  if len(args) > 0 {
+    return fmt.Errorf("selftest: unexpected arguments %q", args)
+  }
+  failed := 0
+  for _, c := range selftestChecks {
+    if err := c.check(); err != nil {
+      fmt.Printf("FAIL %s: %v\n", c.name, err)
+      failed++
+      continue
+    }
+    fmt.Printf("ok   %s\n", c.name)
+  }
+  if failed > 0 {
+    return fmt.Errorf("selftest: %d of %d checks failed", failed, len(selftestChecks))
+  }
+  return nil
+}
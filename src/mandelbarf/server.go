@@ -0,0 +1,566 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "errors"
+  "flag"
+  "fmt"
+  "log/slog"
+  "math"
+  "net"
+  "net/http"
+  "net/url"
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+const serveMaxDim = 2048 // Default largest view, in pixels on a side, a client may ask for
+const serveRate = 2.0 // Renders per second allowed per client
+const serveBurst = 5 // Renders a client may make at once before being limited
+const tileSize = 256 // Pixels on a side of a tile
+const maxTileZoom = 48 // Beyond this, tiles are smaller than float64 can resolve
+
+// Explorer page: drag a box to zoom in, double-click to zoom out
+const explorerPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>mandelbarf</title>
+<style>
+  body { margin: 0; overflow: hidden; background: black; }
+  img { display: block; width: 100vw; height: 100vh; }
+  #box { position: absolute; border: 1px solid white; display: none; pointer-events: none; }
+</style>
+</head>
+<body>
+<img id="view">
+<div id="box"></div>
+<script>
+const baseWidth = %g;
+const maxDim = %d; // Largest view the server renders; it's stretched to fit
+let view = {cx: %g, cy: %g, zoom: 1};
+const session = Math.random().toString(36).slice(2); // New renders supersede old ones
+const img = document.getElementById("view");
+const box = document.getElementById("box");
+
+function show() {
+  const shrink = Math.min(1, maxDim / Math.max(window.innerWidth, window.innerHeight));
+  const w = Math.max(1, Math.floor(window.innerWidth * shrink));
+  const h = Math.max(1, Math.floor(window.innerHeight * shrink));
+  img.src = "/render?cx=" + view.cx + "&cy=" + view.cy + "&zoom=" + view.zoom +
+    "&w=" + w + "&h=" + h + "&session=" + session;
+}
+
+// The point in the complex plane at window coordinates x, y
+function toComplex(x, y) {
+  const pixel = baseWidth / view.zoom / window.innerWidth;
+  return [view.cx + (x - window.innerWidth / 2) * pixel,
+          view.cy - (y - window.innerHeight / 2) * pixel];
+}
+
+let start = null;
+img.addEventListener("mousedown", e => {
+  e.preventDefault();
+  start = [e.clientX, e.clientY];
+});
+window.addEventListener("mousemove", e => {
+  if (!start) return;
+  box.style.display = "block";
+  box.style.left = Math.min(start[0], e.clientX) + "px";
+  box.style.top = Math.min(start[1], e.clientY) + "px";
+  box.style.width = Math.abs(e.clientX - start[0]) + "px";
+  box.style.height = Math.abs(e.clientY - start[1]) + "px";
+});
+window.addEventListener("mouseup", e => {
+  if (!start) return;
+  box.style.display = "none";
+  const w = Math.abs(e.clientX - start[0]), h = Math.abs(e.clientY - start[1]);
+  if (w > 4 && h > 4) {
+    [view.cx, view.cy] = toComplex((start[0] + e.clientX) / 2, (start[1] + e.clientY) / 2);
+    view.zoom *= Math.min(window.innerWidth / w, window.innerHeight / h);
+    show();
+  }
+  start = null;
+});
+img.addEventListener("dblclick", e => {
+  view.zoom = Math.max(1, view.zoom / 2);
+  show();
+});
+window.addEventListener("resize", show);
+show();
+</script>
+</body>
+</html>
+`
+
+// Per-client token buckets limiting how often each may render. A full
+// bucket is no different from a new one, so full ones are swept out, and
+// only clients that rendered in the last few seconds take up memory.
+type rateLimiter struct {
+  mu sync.Mutex
+  buckets map[string]*bucket
+  swept time.Time // When full buckets were last swept out
+}
+
+type bucket struct {
+  tokens float64
+  last time.Time
+}
+
+// Whether client may render now, spending a token if so
+func (l *rateLimiter) allow(client string) bool {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  now := time.Now()
+  // Every bucket refills in this long, so sweeping more often finds few
+  refill := time.Duration(serveBurst / serveRate * float64(time.Second))
+  if now.Sub(l.swept) >= refill {
+    for c, b := range l.buckets {
+      if b.tokens + now.Sub(b.last).Seconds() * serveRate >= serveBurst {
+        delete(l.buckets, c)
+      }
+    }
+    l.swept = now
+  }
+  b := l.buckets[client]
+  if b == nil {
+    b = &bucket{serveBurst, now}
+    l.buckets[client] = b
+  }
+  b.tokens = min(serveBurst, b.tokens + now.Sub(b.last).Seconds() * serveRate)
+  b.last = now
+  if b.tokens < 1 {
+    return false
+  }
+  b.tokens--
+  return true
+}
+
+// Serves the explorer page and renders views of base
+type server struct {
+  base RenderConfig
+  autoIter bool // Pick the iteration cap from each view's zoom
+  maxDim int // Refuse views bigger than this on a side
+  png pngOptions // For rendered views; tiles aren't interlaced
+  format string // Rendered views' format when the client doesn't pick one: "png" or "jpeg"
+  jpegQuality int
+  limiter rateLimiter
+
+  mu sync.Mutex
+  inFlight map[string]*flight // Each client's latest render
+
+  tiles *lruCache
+
+  // Semaphores: slots holds a token per render running, queue one per
+  // render running or waiting for a slot
+  slots, queue chan struct{}
+}
+
+type flight struct {
+  cancel context.CancelFunc
+}
+
+// Start a render for client, cancelling any it already has in flight, so
+// rapid zooming doesn't pile up abandoned renders. The returned function must
+// be called when the render is done.
+func (s *server) begin(parent context.Context, client string) (context.Context, func()) {
+  ctx, cancel := context.WithCancel(parent)
+  f := &flight{cancel}
+  s.mu.Lock()
+  if old := s.inFlight[client]; old != nil {
+    old.cancel()
+  }
+  s.inFlight[client] = f
+  s.mu.Unlock()
+  return ctx, func() {
+    cancel()
+    s.mu.Lock()
+    if s.inFlight[client] == f {
+      delete(s.inFlight, client)
+    }
+    s.mu.Unlock()
+  }
+}
+
+// Wait for a render slot, unless the queue is already full or ctx is done
+// first. Each render has its own workers, so the slots bound how many share
+// the CPU at a time. If it returns true, release must be called once the
+// render is done.
+func (s *server) acquire(ctx context.Context) bool {
+  select {
+  case s.queue <- struct{}{}:
+  default:
+    return false
+  }
+  select {
+  case s.slots <- struct{}{}:
+    return true
+  case <- ctx.Done():
+    <- s.queue
+    return false
+  }
+}
+
+func (s *server) release() {
+  <- s.slots
+  <- s.queue
+}
+
+// Tell the client the server's too busy to render now
+func busy(w http.ResponseWriter) {
+  w.Header().Set("Retry-After", "1")
+  http.Error(w, "too busy", http.StatusServiceUnavailable)
+}
+
+func (s *server) page(w http.ResponseWriter, r *http.Request) {
+  if r.URL.Path != "/" {
+    http.NotFound(w, r)
+    return
+  }
+  cx, cy := (s.base.XMin + s.base.XMax) / 2, (s.base.YMin + s.base.YMax) / 2
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  fmt.Fprintf(w, explorerPage, s.base.XMax - s.base.XMin, s.maxDim, cx, cy)
+}
+
+// Parse the view requested by r's query: center cx,cy, zoom relative to the
+// base view, and w x h pixels
+func (s *server) viewConfig(r *http.Request) (RenderConfig, error) {
+  cfg := s.base
+  q := r.URL.Query()
+  num := func(name string, def float64) (float64, error) {
+    if q.Get(name) == "" {
+      return def, nil
+    }
+    v, err := strconv.ParseFloat(q.Get(name), 64)
+    if err != nil {
+      return 0, fmt.Errorf("%s: %v", name, err)
+    }
+    return v, nil
+  }
+  cx, err := num("cx", (cfg.XMin + cfg.XMax) / 2)
+  if err != nil {
+    return cfg, err
+  }
+  cy, err := num("cy", (cfg.YMin + cfg.YMax) / 2)
+  if err != nil {
+    return cfg, err
+  }
+  zoom, err := num("zoom", 1)
+  if err != nil {
+    return cfg, err
+  }
+  cols, err := num("w", float64(cfg.Cols))
+  if err != nil {
+    return cfg, err
+  }
+  rows, err := num("h", float64(cfg.Rows))
+  if err != nil {
+    return cfg, err
+  }
+  if !(zoom > 0) || !(cols >= 1) || !(rows >= 1) {
+    return cfg, fmt.Errorf("bad zoom %g or size %gx%g", zoom, cols, rows)
+  }
+  if cols > float64(s.maxDim) || rows > float64(s.maxDim) {
+    return cfg, fmt.Errorf("size %gx%g is over the limit of %d on a side", cols, rows, s.maxDim)
+  }
+
+  RegionAt(complex(cx, cy), s.base.XMax - s.base.XMin, int(cols), int(rows)).ZoomIn(zoom).Apply(&cfg)
+  if s.autoIter {
+    cfg.Iterations = cfg.autoIterations()
+  }
+  if q.Get("iterations") != "" {
+    cfg.Iterations, err = strconv.Atoi(q.Get("iterations"))
+    if err != nil {
+      return cfg, fmt.Errorf("iterations: %v", err)
+    }
+  }
+  return cfg, cfg.validate()
+}
+
+// Who sent r, for rate limits and sessions
+func clientOf(r *http.Request) string {
+  client, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    return r.RemoteAddr
+  }
+  return client
+}
+
+func (s *server) render(w http.ResponseWriter, r *http.Request) {
+  client := clientOf(r)
+  if !s.limiter.allow(client) {
+    http.Error(w, "too many requests", http.StatusTooManyRequests)
+    return
+  }
+  cfg, err := s.viewConfig(r)
+  if err != nil {
+    // Including views too big for the size or memory limits
+    slog.Info("render refused", "client", client, "query", r.URL.RawQuery, "err", err)
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  // Renders stop when the connection drops or the client asks for another
+  session := client
+  if id := r.URL.Query().Get("session"); id != "" {
+    session = client + "/" + id
+  }
+  ctx, done := s.begin(r.Context(), session)
+  defer done()
+
+  start := time.Now()
+  if !s.acquire(ctx) {
+    if ctx.Err() != nil {
+      http.Error(w, "superseded", http.StatusServiceUnavailable)
+    } else {
+      busy(w)
+    }
+    return
+  }
+  m, err := renderContext(ctx, cfg)
+  s.release()
+  if ctx.Err() != nil {
+    slog.Info("render cancelled", "client", client, "query", r.URL.RawQuery, "elapsed", time.Since(start))
+    http.Error(w, "superseded", http.StatusServiceUnavailable)
+    return
+  }
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  var buf bytes.Buffer
+  format := s.viewFormat(r)
+  if !cfg.opaque() {
+    format = "png" // JPEG has no alpha
+  }
+  if format == "jpeg" {
+    err = encodeProgressiveJPEG(&buf, m, s.jpegQuality)
+  } else {
+    err = encodePNG(&buf, m, s.png)
+  }
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  slog.Info("served render", "client", client, "query", r.URL.RawQuery, "format", format, "elapsed", time.Since(start))
+  w.Header().Set("Content-Type", "image/" + format)
+  w.Header().Set("Vary", "Accept")
+  w.Write(buf.Bytes())
+}
+
+// The format to send r's view in: the query's format=png or jpeg if it has
+// one; otherwise the one of the two r's Accept header takes if it takes just
+// one; otherwise the server's. Browsers take image/*, so they get the
+// server's.
+func (s *server) viewFormat(r *http.Request) string {
+  if f := r.URL.Query().Get("format"); f == "png" || f == "jpeg" {
+    return f
+  }
+  accepts := map[string]bool{}
+  for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+    params := strings.Split(part, ";")
+    q := 1.0 // Preference; 0 refuses
+    for _, p := range params[1:] {
+      if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+        q, _ = strconv.ParseFloat(v, 64)
+      }
+    }
+    if q > 0 {
+      accepts[strings.TrimSpace(params[0])] = true
+    }
+  }
+  png, jpeg := accepts["image/png"], accepts["image/jpeg"]
+  switch {
+  case jpeg && !png:
+    return "jpeg"
+  case png && !jpeg:
+    return "png"
+  }
+  return s.format
+}
+
+// Serve /tile/z/x/y.png: tile x,y of the 2^z by 2^z tiles covering a square centered on the
+// fractal's default view. The palette and fractal may be chosen by name in
+// the query; otherwise they are the server's.
+func (s *server) tile(w http.ResponseWriter, r *http.Request) {
+  var z, x, y int
+  var err error
+  coords := strings.Split(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tile/"), ".png"), "/")
+  if len(coords) != 3 {
+    http.NotFound(w, r)
+    return
+  }
+  for i, p := range []*int{&z, &x, &y} {
+    if *p, err = strconv.Atoi(coords[i]); err != nil {
+      http.Error(w, "bad tile coordinates", http.StatusBadRequest)
+      return
+    }
+  }
+  n := 1 << min(max(z, 0), maxTileZoom)
+  if z < 0 || z > maxTileZoom || x < 0 || x >= n || y < 0 || y >= n {
+    http.Error(w, "no such tile", http.StatusNotFound)
+    return
+  }
+  cfg, err := s.tileConfig(z, x, y, r.URL.Query())
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  key := tileKeyOf(cfg)
+  if data, ok := s.tiles.get(key); ok {
+    w.Header().Set("Content-Type", "image/png")
+    w.Write(data)
+    return
+  }
+
+  // Only renders count against the limit, so a cached tile is always served
+  if !s.limiter.allow(clientOf(r)) {
+    http.Error(w, "too many requests", http.StatusTooManyRequests)
+    return
+  }
+  if !s.acquire(r.Context()) {
+    if r.Context().Err() != nil {
+      http.Error(w, "cancelled", http.StatusServiceUnavailable)
+    } else {
+      busy(w)
+    }
+    return
+  }
+  m, err := renderContext(r.Context(), cfg)
+  s.release()
+  // The client went away, which isn't the server failing
+  if r.Context().Err() != nil {
+    http.Error(w, "cancelled", http.StatusServiceUnavailable)
+    return
+  }
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  var buf bytes.Buffer
+  tileOpts := s.png
+  tileOpts.interlace = false
+  if err := encodePNG(&buf, m, tileOpts); err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  s.tiles.put(key, buf.Bytes())
+  w.Header().Set("Content-Type", "image/png")
+  w.Write(buf.Bytes())
+}
+
+// The config drawing tile x,y at zoom z, with the palette and fractal q
+// names, if it names them. Its bounds are snapped to a grid of a power of
+// two no coarser than 1/256 of a pixel. Multiples of that are exact, so the
+// snapped bounds are the same bits however the arithmetic before them
+// rounded.
+func (s *server) tileConfig(z, x, y int, q url.Values) (RenderConfig, error) {
+  cfg := s.base
+  if name := q.Get("palette"); name != "" {
+    if cfg.Palette = palettes[name]; cfg.Palette == nil {
+      return cfg, errors.New("unknown palette")
+    }
+  }
+  bounds := [4]float64{cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax}
+  if name := q.Get("fractal"); name != "" {
+    f, ok := fractals[name]
+    if !ok {
+      return cfg, errors.New("unknown fractal")
+    }
+    cfg.Fractal, bounds = name, f.bounds
+  }
+  n := 1 << z
+  side := (bounds[1] - bounds[0]) / float64(n)
+  left := (bounds[0] + bounds[1]) / 2 - side * float64(n) / 2
+  top := (bounds[2] + bounds[3]) / 2 + side * float64(n) / 2
+  _, exp := math.Frexp(side / tileSize) // A pixel is at least 2^(exp-1)
+  grid := math.Ldexp(1, exp - 9)
+  snap := func(v float64) float64 {
+    return math.Round(v / grid) * grid
+  }
+  cfg.XMin, cfg.XMax = snap(left + side * float64(x)), snap(left + side * float64(x + 1))
+  cfg.YMax, cfg.YMin = snap(top - side * float64(y)), snap(top - side * float64(y + 1))
+  cfg.Cols, cfg.Rows = tileSize, tileSize
+  if s.autoIter {
+    cfg.Iterations = cfg.autoIterations()
+  }
+  return cfg, nil
+}
+
+// The cache key of the tile cfg draws
+func tileKeyOf(cfg RenderConfig) tileKey {
+  key := tileKey{cols: cfg.Cols, rows: cfg.Rows, iterations: cfg.Iterations, fractal: cfg.Fractal}
+  for i, v := range [4]float64{cfg.XMin, cfg.XMax, cfg.YMin, cfg.YMax} {
+    key.bounds[i] = math.Float64bits(v)
+  }
+  palette := make([]byte, 0, 4 * len(cfg.Palette))
+  for _, c := range cfg.Palette {
+    palette = append(palette, c.R, c.G, c.B, c.A)
+  }
+  key.palette = string(palette)
+  return key
+}
+
+// Serve an interactive explorer over HTTP
+func serveCmd(args []string) error {
+  fs := flag.NewFlagSet("serve", flag.ExitOnError)
+  config := renderFlags(fs)
+  verbosity := logFlags(fs)
+  addr := fs.String("addr", "localhost:8080", "listen on this address")
+  cacheMB := fs.Int("tile-cache", 64, "cache up to this many megabytes of tiles")
+  interlace := fs.Bool("interlace", false, "interlace rendered views (not tiles), so browsers show them progressively")
+  compression := fs.String("compression", "default", "PNG compression: default, fast, best, or none; fast or none saves latency")
+  concurrency := fs.Int("server-concurrency", 2, "render at most this many views and tiles at once, each with its own -workers")
+  format := fs.String("view-format", "png", "send rendered views as png, or as progressive jpeg, which loads faster on slow links, "+
+    "unless the request picks one by format=png or jpeg or its Accept header")
+  jpegQuality := fs.Int("jpeg-quality", 85, "quality of jpeg views, from 1 to 100")
+  maxDim := fs.Int("server-max-dim", serveMaxDim, "refuse, with 400, views asking for more than this many pixels on a side")
+  queueDepth := fs.Int("server-queue", 16, "let this many more renders wait for a turn, and turn away the rest with 503")
+  fs.Parse(args)
+  verbosity()
+  cfg, err := config()
+  if err != nil {
+    return err
+  }
+  if err := cfg.validate(); err != nil {
+    return err
+  }
+
+  pngOpts, err := parsePNGOptions("srgb", *interlace, *compression)
+  if err != nil {
+    return err
+  }
+  if *format != "png" && *format != "jpeg" {
+    return fmt.Errorf("unknown view format %q", *format)
+  }
+  if *jpegQuality < 1 || *jpegQuality > 100 {
+    return fmt.Errorf("-jpeg-quality must be from 1 to 100, got %d", *jpegQuality)
+  }
+  if *maxDim < 1 {
+    return fmt.Errorf("-server-max-dim must be at least 1, got %d", *maxDim)
+  }
+  if *concurrency < 1 || *queueDepth < 0 {
+    return fmt.Errorf("-server-concurrency must be at least 1 and -server-queue not negative, got %d and %d",
+      *concurrency, *queueDepth)
+  }
+
+  if !flagSet(fs, "chunk-order") {
+    cfg.ChunkOrder = "center"
+  }
+  s := &server{base: cfg, autoIter: !flagSet(fs, "iterations"), maxDim: *maxDim, png: pngOpts,
+    format: *format, jpegQuality: *jpegQuality}
+  s.limiter.buckets = map[string]*bucket{}
+  s.inFlight = map[string]*flight{}
+  s.tiles = newLRUCache(*cacheMB << 20)
+  s.slots = make(chan struct{}, *concurrency)
+  s.queue = make(chan struct{}, *concurrency + *queueDepth)
+  mux := http.NewServeMux()
+  mux.HandleFunc("/", s.page)
+  mux.HandleFunc("/render", s.render)
+  mux.HandleFunc("/tile/", s.tile)
+  fmt.Fprintf(os.Stderr, "serving on http://%s/\n", *addr)
+  return http.ListenAndServe(*addr, mux)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "flag"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "testing"
+)
+
+// A server as serveCmd would start with args, without listening
+func testServer(t *testing.T, args ...string) *server {
+  t.Helper()
+  fs := flag.NewFlagSet("test", flag.ContinueOnError)
+  config := renderFlags(fs)
+  if err := fs.Parse(append([]string{"-scale", "2"}, args...)); err != nil {
+    t.Fatal(err)
+  }
+  cfg, err := config()
+  if err != nil {
+    t.Fatal(err)
+  }
+  pngOpts, err := parsePNGOptions("srgb", false, "fast")
+  if err != nil {
+    t.Fatal(err)
+  }
+  s := &server{base: cfg, autoIter: !flagSet(fs, "iterations"), png: pngOpts, tiles: newLRUCache(1 << 20),
+    slots: make(chan struct{}, 1), queue: make(chan struct{}, 1)}
+  s.limiter.buckets = map[string]*bucket{}
+  return s
+}
+
+// The body served for path, failing unless it's a 200
+func getTile(t *testing.T, s *server, path string) []byte {
+  t.Helper()
+  w := httptest.NewRecorder()
+  s.tile(w, httptest.NewRequest("GET", path, nil))
+  if w.Code != http.StatusOK {
+    t.Fatalf("%s: %d %s", path, w.Code, w.Body)
+  }
+  return w.Body.Bytes()
+}
+
+// Two servers started alike key a tile the same and draw it as the same
+// bytes, as does asking for the server's own palette and fractal by name
+func TestTileKeyDeterministic(t *testing.T) {
+  a, b := testServer(t), testServer(t)
+  for _, tile := range [][3]int{{0, 0, 0}, {3, 2, 5}, {20, 600000, 400000}, {maxTileZoom, 1 << 47, 1 << 46}} {
+    query := url.Values{}
+    ka, err := a.tileConfig(tile[0], tile[1], tile[2], query)
+    if err != nil {
+      t.Fatal(err)
+    }
+    kb, _ := b.tileConfig(tile[0], tile[1], tile[2], url.Values{"palette": {"cyan"}, "fractal": {"mandelbrot"}})
+    if tileKeyOf(ka) != tileKeyOf(kb) {
+      t.Errorf("tile %v: keys differ, %+v and %+v", tile, tileKeyOf(ka), tileKeyOf(kb))
+    }
+  }
+
+  path := "/tile/3/2/5.png"
+  first := getTile(t, a, path)
+  if !bytes.Equal(getTile(t, b, path + "?palette=cyan&fractal=mandelbrot"), first) {
+    t.Errorf("%s: independent servers drew different bytes", path)
+  }
+  if !bytes.Equal(getTile(t, a, path), first) || a.tiles.size != len(first) {
+    t.Errorf("%s: asking again didn't serve the cached tile alone", path)
+  }
+
+  // Anything drawn differently is keyed differently
+  base, _ := a.tileConfig(3, 2, 5, url.Values{})
+  for _, q := range []url.Values{{"palette": {"fire"}}, {"fractal": {"tricorn"}}} {
+    cfg, err := a.tileConfig(3, 2, 5, q)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if tileKeyOf(cfg) == tileKeyOf(base) {
+      t.Errorf("%v: keyed the same as the server's tile", q)
+    }
+  }
+  next, _ := a.tileConfig(3, 3, 5, url.Values{})
+  deeper := testServer(t, "-iterations", "500")
+  more, _ := deeper.tileConfig(3, 2, 5, url.Values{})
+  for name, cfg := range map[string]RenderConfig{"the next tile": next, fmt.Sprintf("%d iterations", more.Iterations): more} {
+    if tileKeyOf(cfg) == tileKeyOf(base) {
+      t.Errorf("%s keyed the same as tile 3/2/5", name)
+    }
+  }
+}
+
+// Uncached tiles count against the client's rate limit but cached ones
+// don't, and a request cancelled mid-render isn't a server error
+func TestTileLimits(t *testing.T) {
+  s := testServer(t)
+  first := "/tile/2/0/0.png"
+  getTile(t, s, first)
+  for i := 1; i < serveBurst; i++ {
+    getTile(t, s, fmt.Sprintf("/tile/3/%d/0.png", i))
+  }
+  w := httptest.NewRecorder()
+  s.tile(w, httptest.NewRequest("GET", "/tile/3/7/7.png", nil))
+  if w.Code != http.StatusTooManyRequests {
+    t.Errorf("tile past the burst: %d, want %d", w.Code, http.StatusTooManyRequests)
+  }
+  getTile(t, s, first)
+
+  s = testServer(t)
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  w = httptest.NewRecorder()
+  s.tile(w, httptest.NewRequest("GET", first, nil).WithContext(ctx))
+  if w.Code != http.StatusServiceUnavailable {
+    t.Errorf("cancelled tile: %d %s, want %d", w.Code, w.Body, http.StatusServiceUnavailable)
+  }
+}
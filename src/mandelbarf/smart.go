@@ -0,0 +1,163 @@
+package main
+
+import (
+  "context"
+  "image/color"
+  "log/slog"
+  "time"
+)
+
+const aaThreshold = 0.002 // Default variance above which -aa smart refines a pixel
+
+// Render with one sample per pixel, then find the pixels whose 3x3
+// neighborhoods vary more than cfg.AAThreshold, where edges and fine detail
+// are, and resample just those. They get ScaleX by ScaleY samples per pixel
+// over a window a pixel either side of their center, combined with lanczos
+// weights. Flat regions, most of a typical image, keep their single
+// sample.
+func renderSmart(ctx context.Context, cfg RenderConfig, emit func(row int, pixels []color.RGBA)) error {
+  start := time.Now()
+  // Integer x,y are pixel centers
+  colorAt := cfg.pointColorer(newColorer(cfg), cfg.Cols * cfg.ScaleX, cfg.Rows * cfg.ScaleY, cfg.outputScale())
+  at := func(x, y float64) color.RGBA {
+    c, _, _ := colorAt(x, y, cfg.Iterations)
+    return c
+  }
+
+  // The coarse samples are at the pixel centers, so they're the escapes kept
+  keep := cfg.escapeKeeper(cfg.Cols, cfg.Rows)
+  coarse := mkImg(cfg.Cols, cfg.Rows)
+  eachRow(ctx, cfg, func(row int) {
+    for col := 0; col < cfg.Cols; col++ {
+      c, v, z := colorAt(float64(col), float64(row), cfg.Iterations)
+      if keep != nil {
+        keep(col, row, v, cfg.Iterations, z)
+      }
+      coarse.set(col, row, c)
+    }
+  })
+  if err := ctx.Err(); err != nil {
+    return err
+  }
+
+  // Sample offsets within the window, in output pixels, and their weights
+  offsets := func(n int) ([]float64, []float64) {
+    d, w := make([]float64, 2 * n), make([]float64, 2 * n)
+    for i := range d {
+      d[i] = (float64(i) + 0.5) / float64(n) - 1
+      w[i] = lanczos(d[i], 1)
+    }
+    return d, w
+  }
+  dx, wx := offsets(cfg.ScaleX)
+  dy, wy := offsets(cfg.ScaleY)
+
+  out := mkImg(cfg.Cols, cfg.Rows)
+  refined := make([]int, cfg.Rows)
+  eachRow(ctx, cfg, func(row int) {
+    for col := 0; col < cfg.Cols; col++ {
+      if neighborhoodVariance(coarse, col, row, cfg.WrapX) <= cfg.AAThreshold {
+        out.set(col, row, coarse.get(col, row))
+        continue
+      }
+      refined[row]++
+      var sum [4]float64
+      weight := 0.0
+      for j, oy := range dy {
+        for i, ox := range dx {
+          w := wx[i] * wy[j]
+          c := channels(at(float64(col) + ox, float64(row) + oy), cfg.LinearLight)
+          for k := range sum {
+            sum[k] += w * c[k]
+          }
+          weight += w
+        }
+      }
+      for k := range sum {
+        sum[k] /= weight
+      }
+      out.set(col, row, fromChannels(sum, cfg.LinearLight))
+    }
+  })
+  if err := ctx.Err(); err != nil {
+    return err
+  }
+  total := 0
+  for _, n := range refined {
+    total += n
+  }
+  slog.Info("render done", "refined", total, "of", cfg.Cols * cfg.Rows, "elapsed", time.Since(start))
+  for row := 0; row < out.rows; row++ {
+    emit(row, out.px[row * out.cols:(row + 1) * out.cols])
+  }
+  return nil
+}
+
+// Call f on every row of the output, spread among cfg.Workers, until ctx is
+// done
+func eachRow(ctx context.Context, cfg RenderConfig, f func(row int)) {
+  rows := make(chan int, cfg.Rows)
+  for r := 0; r < cfg.Rows; r++ {
+    rows <- r
+  }
+  close(rows)
+  flags := make(chan int, cfg.Workers)
+  for w := 0; w < cfg.Workers; w++ {
+    go func() {
+      defer cfg.pinWorker(w)()
+      for r := range rows {
+        if ctx.Err() == nil {
+          f(r)
+        }
+      }
+      flags <- 0
+    }()
+  }
+  for w := 0; w < cfg.Workers; w++ {
+    <- flags
+  }
+}
+
+// The variance of the colors in the 3x3 block around x,y (fewer at the
+// edges, or at the top and bottom only with wrapX)
+func neighborhoodVariance(i img, x, y int, wrapX bool) float64 {
+  var s colorStats
+  for r := max(0, y - 1); r <= min(i.rows - 1, y + 1); r++ {
+    if wrapX && i.cols >= 3 {
+      for c := x - 1; c <= x + 1; c++ {
+        s.add(i.get((c + i.cols) % i.cols, r))
+      }
+      continue
+    }
+    for c := max(0, x - 1); c <= min(i.cols - 1, x + 1); c++ {
+      s.add(i.get(c, r))
+    }
+  }
+  return s.variance()
+}
+
+// Accumulates the variance of a set of colors
+type colorStats struct {
+  n float64
+  sum, sumSq [4]float64
+}
+
+func (s *colorStats) add(p color.RGBA) {
+  for k, v := range [4]uint8{p.R, p.G, p.B, p.A} {
+    f := float64(v) / 255
+    s.sum[k] += f
+    s.sumSq[k] += f * f
+  }
+  s.n++
+}
+
+// The variance of the colors so far, averaged over the channels, with
+// channels scaled to 0 to 1
+func (s *colorStats) variance() float64 {
+  variance := 0.0
+  for k := range s.sum {
+    mean := s.sum[k] / s.n
+    variance += s.sumSq[k] / s.n - mean * mean
+  }
+  return variance / 4
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "math"
+  "os"
+  "sort"
+)
+
+// Edges of a marching squares cell, in which the contour crosses
+const (
+  edgeTop = iota
+  edgeRight
+  edgeBottom
+  edgeLeft
+)
+
+// The contour's segments through a cell, by which of its corners are inside:
+// top left 8, top right 4, bottom right 2, bottom left 1. The two saddles,
+// 5 and 10, are taken to separate their inside corners.
+var cellSegments = [16][][2]int{
+  1: {{edgeLeft, edgeBottom}},
+  2: {{edgeBottom, edgeRight}},
+  3: {{edgeLeft, edgeRight}},
+  4: {{edgeTop, edgeRight}},
+  5: {{edgeTop, edgeRight}, {edgeLeft, edgeBottom}},
+  6: {{edgeTop, edgeBottom}},
+  7: {{edgeTop, edgeLeft}},
+  8: {{edgeTop, edgeLeft}},
+  9: {{edgeTop, edgeBottom}},
+  10: {{edgeTop, edgeLeft}, {edgeBottom, edgeRight}},
+  11: {{edgeTop, edgeRight}},
+  12: {{edgeLeft, edgeRight}},
+  13: {{edgeBottom, edgeRight}},
+  14: {{edgeLeft, edgeBottom}},
+}
+
+type point struct{ x, y float64 }
+
+// Trace the contour around the output pixels taking at least threshold
+// iterations to escape in e, by marching squares over the pixel centers.
+// The grid is padded with a ring of outside points, so the contour is a set
+// of closed rings, in output pixel coordinates.
+func traceBoundary(e *EscapeMap, threshold int) [][]point {
+  cols, rows := e.Cols + 2, e.Rows + 2
+  inside := make([]bool, cols * rows)
+  for row := 0; row < e.Rows; row++ {
+    for col := 0; col < e.Cols; col++ {
+      inside[(row + 1) * cols + col + 1] = int(e.Iter[row * e.Cols + col]) >= threshold
+    }
+  }
+
+  // Crossings are named by the grid edge they're on: twice the index of
+  // its top or left end, plus 1 if it's vertical. Each joins two segments.
+  edgeID := func(x, y, edge int) int {
+    switch edge {
+    case edgeTop:
+      return 2 * (y * cols + x)
+    case edgeRight:
+      return 2 * (y * cols + x + 1) + 1
+    case edgeBottom:
+      return 2 * ((y + 1) * cols + x)
+    }
+    return 2 * (y * cols + x) + 1
+  }
+  links := map[int][]int{}
+  for y := 0; y < rows - 1; y++ {
+    for x := 0; x < cols - 1; x++ {
+      corners := 0
+      for i, in := range []bool{inside[(y + 1) * cols + x], inside[(y + 1) * cols + x + 1],
+        inside[y * cols + x + 1], inside[y * cols + x]} {
+        if in {
+          corners |= 1 << i
+        }
+      }
+      for _, s := range cellSegments[corners] {
+        a, b := edgeID(x, y, s[0]), edgeID(x, y, s[1])
+        links[a] = append(links[a], b)
+        links[b] = append(links[b], a)
+      }
+    }
+  }
+
+  // Follow the links around each ring. Grid point x,y is the center of
+  // output pixel x-1,y-1, which spans x-1 to x.
+  at := func(id int) point {
+    x, y := float64(id / 2 % cols) - 0.5, float64(id / 2 / cols) - 0.5
+    if id % 2 == 0 {
+      return point{x + 0.5, y}
+    }
+    return point{x, y + 0.5}
+  }
+  starts := make([]int, 0, len(links))
+  for id := range links {
+    starts = append(starts, id)
+  }
+  sort.Ints(starts) // For the same SVG every time
+  var rings [][]point
+  seen := map[int]bool{}
+  for _, start := range starts {
+    if seen[start] {
+      continue
+    }
+    var ring []point
+    for prev, id := -1, start; !seen[id]; {
+      seen[id] = true
+      ring = append(ring, at(id))
+      next := links[id][0]
+      if next == prev {
+        next = links[id][1]
+      }
+      prev, id = id, next
+    }
+    rings = append(rings, ring)
+  }
+  return rings
+}
+
+// Simplify a closed ring by Ramer-Douglas-Peucker, keeping it within
+// tolerance of the original
+func simplifyRing(ring []point, tolerance float64) []point {
+  if len(ring) < 4 {
+    return ring
+  }
+  closed := append(ring[:len(ring):len(ring)], ring[0])
+  keep := make([]bool, len(closed))
+  keep[0], keep[len(closed) - 1] = true, true
+  // The ring's ends coincide, so split it at the point farthest from them
+  far, farDist := 0, 0.0
+  for i, p := range ring {
+    if d := math.Hypot(p.x - ring[0].x, p.y - ring[0].y); d > farDist {
+      far, farDist = i, d
+    }
+  }
+  keep[far] = true
+  var simplify func(lo, hi int)
+  simplify = func(lo, hi int) {
+    a, b := closed[lo], closed[hi]
+    dx, dy := b.x - a.x, b.y - a.y
+    length := math.Hypot(dx, dy)
+    worst, worstDist := -1, tolerance
+    for i := lo + 1; i < hi; i++ {
+      p := closed[i]
+      d := math.Hypot(p.x - a.x, p.y - a.y)
+      if length > 0 {
+        d = math.Abs(dy * (p.x - a.x) - dx * (p.y - a.y)) / length
+      }
+      if d > worstDist {
+        worst, worstDist = i, d
+      }
+    }
+    if worst >= 0 {
+      keep[worst] = true
+      simplify(lo, worst)
+      simplify(worst, hi)
+    }
+  }
+  simplify(0, far)
+  simplify(far, len(closed) - 1)
+  var out []point
+  for i, p := range ring {
+    if keep[i] {
+      out = append(out, p)
+    }
+  }
+  return out
+}
+
+// Write the boundary of the points taking at least threshold iterations to
+// escape in e to w, as an SVG path over the output's pixels, simplified to
+// within tolerance pixels
+func writeSVG(w io.Writer, e *EscapeMap, threshold int, tolerance float64) error {
+  rings := traceBoundary(e, threshold)
+  bw := bufio.NewWriter(w)
+  fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" viewBox=\"0 0 %d %d\" width=\"%d\" height=\"%d\">\n",
+    e.Cols, e.Rows, e.Cols, e.Rows)
+  fmt.Fprintf(bw, "<path fill=\"black\" fill-rule=\"evenodd\" d=\"")
+  for _, ring := range rings {
+    ring = simplifyRing(ring, tolerance)
+    if len(ring) < 3 {
+      continue // A speck smaller than the tolerance
+    }
+    for i, p := range ring {
+      cmd := "L"
+      if i == 0 {
+        cmd = "M"
+      }
+      fmt.Fprintf(bw, "%s%g %g", cmd, p.x, p.y)
+    }
+    fmt.Fprintf(bw, "Z")
+  }
+  fmt.Fprintf(bw, "\"/>\n</svg>\n")
+  return bw.Flush()
+}
+
+// Write the boundary SVG of e to a file
+func saveSVG(fileName string, e *EscapeMap, threshold int, tolerance float64) error {
+  file, err := os.Create(fileName)
+  if err != nil {
+    return &EncodeError{fileName, err}
+  }
+  defer file.Close()
+  if err := writeSVG(file, e, threshold, tolerance); err != nil {
+    return &EncodeError{fileName, err}
+  }
+  if err := file.Close(); err != nil {
+    return &EncodeError{fileName, err}
+  }
+  return nil
+}
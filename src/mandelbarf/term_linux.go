@@ -0,0 +1,53 @@
+//go:build linux
+
+package main
+
+import (
+  "os"
+  "os/signal"
+  "syscall"
+  "unsafe"
+)
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+  _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+  if errno != 0 {
+    return errno
+  }
+  return nil
+}
+
+// Put the terminal on fd in raw mode, returning a function to restore it
+func rawTerminal(fd uintptr) (func(), error) {
+  var old syscall.Termios
+  if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+    return nil, err
+  }
+  raw := old
+  raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+    syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+  raw.Oflag &^= syscall.OPOST
+  raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+  raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+  raw.Cflag |= syscall.CS8
+  raw.Cc[syscall.VMIN] = 1
+  raw.Cc[syscall.VTIME] = 0
+  if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+    return nil, err
+  }
+  return func() { ioctl(fd, syscall.TCSETS, unsafe.Pointer(&old)) }, nil
+}
+
+// Size of the terminal on fd in characters
+func terminalSize(fd uintptr) (cols, rows int, err error) {
+  var ws struct{ rows, cols, xPixels, yPixels uint16 }
+  if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+    return 0, 0, err
+  }
+  return int(ws.cols), int(ws.rows), nil
+}
+
+// Signal on c when the terminal is resized
+func notifyResize(c chan os.Signal) {
+  signal.Notify(c, syscall.SIGWINCH)
+}
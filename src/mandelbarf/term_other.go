@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+  "errors"
+  "os"
+)
+
+var errNoTerminal = errors.New("-tui is only supported on linux")
+
+func rawTerminal(fd uintptr) (func(), error) {
+  return nil, errNoTerminal
+}
+
+func terminalSize(fd uintptr) (cols, rows int, err error) {
+  return 0, 0, errNoTerminal
+}
+
+func notifyResize(c chan os.Signal) {}
@@ -0,0 +1,112 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "image"
+  "image/color"
+  "math"
+  "os"
+  "time"
+)
+
+const tileFileName = "tile-%d-%d.png" // For -tiles, by column and row of tiles
+const tileOverlap = 4 // Output pixels each tile renders past each edge, by default
+
+// The config rendering just output pixels r of cfg's image, on the same grid
+// of samples and from the same random streams: its pixel x,y is cfg's
+// r.Min.X + x, r.Min.Y + y. r must be at least 2 pixels each way. It moves
+// cfg's bounds, so cfg can't have an Affine, which turns about their center.
+func (cfg RenderConfig) subView(r image.Rectangle) RenderConfig {
+  sub := cfg
+  sub.Cols, sub.Rows = r.Dx(), r.Dy()
+  sub.Offset = cfg.Offset.Add(r.Min)
+  // A pixel lies the same fraction of the way across the bounds whatever
+  // they are, so find sub's fractions with bounds of 0 to 1, then solve
+  // for the bounds putting its first and last pixels where cfg has them
+  sub.XMin, sub.XMax, sub.YMin, sub.YMax = 0, 1, 0, 1
+  u := sub.outputTransform()
+  full := cfg.outputTransform()
+  solve := func(a, b, fa, fb float64) (lo, hi float64) {
+    width := (b - a) / (fb - fa)
+    lo = a - fa * width
+    return lo, lo + width
+  }
+  ux0, _ := u.apply(0, 0)
+  ux1, _ := u.apply(float64(sub.Cols - 1), 0)
+  x0, _ := full.apply(float64(r.Min.X), 0)
+  x1, _ := full.apply(float64(r.Max.X - 1), 0)
+  sub.XMin, sub.XMax = solve(x0, x1, ux0, ux1)
+  // Rows run down from YMax, so the fractions here are of the way from
+  // YMax to YMin
+  _, uy0 := u.apply(0, 0)
+  _, uy1 := u.apply(0, float64(sub.Rows - 1))
+  _, y0 := full.apply(0, float64(r.Min.Y))
+  _, y1 := full.apply(0, float64(r.Max.Y - 1))
+  sub.YMax, sub.YMin = solve(y0, y1, 1 - uy0, 1 - uy1)
+  return sub
+}
+
+// Render cfg's image as nx by ny tiles, each separately, as a farm of
+// machines would, writing tile x,y to tileFileName. Each tile renders
+// overlap output pixels past each of its edges inside the image and then
+// drops them, so antialiasing and filtering near its edges see the same
+// neighbors they would in one big render, and the tiles stitch without
+// seams.
+func renderTiles(cfg RenderConfig, nx, ny, overlap int, opts pngOptions, quiet bool) error {
+  if nx < 1 || ny < 1 || nx > cfg.Cols || ny > cfg.Rows {
+    return fmt.Errorf("-tiles: %dx%d tiles don't fit a %dx%d image", nx, ny, cfg.Cols, cfg.Rows)
+  }
+  if overlap < 0 {
+    return fmt.Errorf("-tile-overlap must not be negative, got %d", overlap)
+  }
+  switch {
+  case cfg.Affine != identity && cfg.Affine != (affine{}):
+    return errors.New("-tiles: can't split a view with -affine")
+  case !cfg.ROI.Empty():
+    return errors.New("-tiles: can't split a view with -roi")
+  case cfg.Mask != nil:
+    return errors.New("-tiles: can't split a view with -mask")
+  case cfg.WrapX:
+    return errors.New("-tiles: can't split a view with -wrap-x, whose edges meet")
+  case cfg.Fractal == "buddhabrot":
+    return errors.New("-tiles: the buddhabrot is normalized over the whole image, so can't be split")
+  case cfg.Supersample != math.Trunc(cfg.Supersample):
+    // Rounded up per tile, it would put each tile's samples on a grid of
+    // its own
+    return fmt.Errorf("-tiles: can't split a view supersampled by %g, which isn't whole", cfg.Supersample)
+  }
+  if err := cfg.validate(); err != nil {
+    return err
+  }
+  start := time.Now()
+  for ty := 0; ty < ny; ty++ {
+    for tx := 0; tx < nx; tx++ {
+      r := image.Rect(tx * cfg.Cols / nx, ty * cfg.Rows / ny, (tx + 1) * cfg.Cols / nx, (ty + 1) * cfg.Rows / ny)
+      wide := r.Inset(-overlap).Intersect(image.Rect(0, 0, cfg.Cols, cfg.Rows))
+      if wide.Dx() < 2 || wide.Dy() < 2 {
+        return fmt.Errorf("-tiles: tile %d,%d is only %dx%d with its overlap; make fewer or overlap more",
+          tx, ty, wide.Dx(), wide.Dy())
+      }
+      m, err := Render(cfg.subView(wide))
+      if err != nil {
+        return err
+      }
+      tile := mkImg(r.Dx(), r.Dy())
+      at := r.Min.Sub(wide.Min)
+      for y := 0; y < tile.rows; y++ {
+        for x := 0; x < tile.cols; x++ {
+          tile.px[y * tile.cols + x] = color.RGBAModel.Convert(m.At(at.X + x, at.Y + y)).(color.RGBA)
+        }
+      }
+      if _, err := writePNG(fmt.Sprintf(tileFileName, tx, ty), tile, opts); err != nil {
+        return err
+      }
+    }
+  }
+  if !quiet {
+    fmt.Fprintf(os.Stderr, "%dx%d tiles of %dx%d, overlapping by %d, in %v\n",
+      nx, ny, cfg.Cols, cfg.Rows, overlap, time.Since(start).Round(time.Millisecond))
+  }
+  return nil
+}
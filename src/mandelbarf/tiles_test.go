@@ -0,0 +1,58 @@
+package main
+
+import (
+  "fmt"
+  "image"
+  "image/color"
+  "image/png"
+  "os"
+  "testing"
+)
+
+// Tiles rendered separately, each overlapping its neighbors, are exactly the
+// pixels of the image rendered whole
+func TestTilesMatchWhole(t *testing.T) {
+  t.Chdir(t.TempDir())
+  for _, args := range [][]string{
+    nil,
+    {"-aa", "smart"},
+    {"-filter", "lanczos"},
+    {"-fractal", "julia", "-coloring", "smooth"},
+    {"-jitter"},
+    {"-jitter", "-filter", "lanczos"},
+  } {
+    cfg := testConfig(t, args...)
+    whole := testRender(t, cfg)
+    if err := renderTiles(cfg, 3, 2, tileOverlap, pngOptions{tag: "none"}, true); err != nil {
+      t.Fatal(err)
+    }
+    for ty := 0; ty < 2; ty++ {
+      for tx := 0; tx < 3; tx++ {
+        file, err := os.Open(fmt.Sprintf(tileFileName, tx, ty))
+        if err != nil {
+          t.Fatal(err)
+        }
+        tile, err := png.Decode(file)
+        file.Close()
+        if err != nil {
+          t.Fatal(err)
+        }
+        at := image.Pt(tx * cfg.Cols / 3, ty * cfg.Rows / 2)
+        b := tile.Bounds()
+        for y := 0; y < b.Dy(); y++ {
+          for x := 0; x < b.Dx(); x++ {
+            got := color.RGBAModel.Convert(tile.At(b.Min.X + x, b.Min.Y + y)).(color.RGBA)
+            if want := whole[(at.Y + y) * cfg.Cols + at.X + x]; got != want {
+              t.Fatalf("%v: tile %d,%d pixel %d,%d is %v, want %v", args, tx, ty, x, y, got, want)
+            }
+          }
+        }
+      }
+    }
+  }
+
+  cfg := testConfig(t, "-scale", "2.5")
+  if err := renderTiles(cfg, 2, 2, tileOverlap, pngOptions{tag: "none"}, true); err == nil {
+    t.Error("tiling at -scale 2.5 didn't fail")
+  }
+}
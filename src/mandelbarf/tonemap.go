@@ -0,0 +1,50 @@
+package main
+
+import "math"
+
+const toneGamma = 2.2 // For the gamma tone map
+const toneKey = 0.18 // Brightness of an average density for reinhard
+
+// Map each density to a brightness from 0 to 1 with the named tone map:
+// "linear", "log", "gamma", or "reinhard". Accumulated densities span too
+// many orders of magnitude to show linearly.
+func toneMap(mode string, density []uint32) []float64 {
+  var most, sum float64
+  for _, d := range density {
+    most = math.Max(most, float64(d))
+    sum += float64(d)
+  }
+  out := make([]float64, len(density))
+  if most == 0 {
+    return out
+  }
+  mean := sum / float64(len(density))
+  white := toneKey * most / mean // Scaled density that maps to 1
+
+  for i, d := range density {
+    x := float64(d)
+    switch mode {
+    case "log":
+      out[i] = math.Log1p(x) / math.Log1p(most)
+    case "gamma":
+      out[i] = math.Pow(x / most, 1 / toneGamma)
+    case "reinhard":
+      l := toneKey * x / mean
+      out[i] = l * (1 + l / (white * white)) / (1 + l)
+    default:
+      out[i] = x / most
+    }
+  }
+  return out
+}
+
+var toneMaps = []string{"linear", "log", "gamma", "reinhard"}
+
+func validToneMap(mode string) bool {
+  for _, m := range toneMaps {
+    if m == mode {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+  "bufio"
+  "context"
+  "fmt"
+  "image"
+  "image/color"
+  "io"
+  "os"
+)
+
+const tuiPan = 0.1 // Pan by this fraction of the view per key press
+const tuiZoom = 1.5 // Zoom by this factor per key press
+
+// Write m to w as colored text, two pixels per character cell using the
+// upper half block, with the top pixel as foreground and the bottom as
+// background
+func writeANSI(w io.Writer, m image.Image) {
+  b := m.Bounds()
+  for y := b.Min.Y; y < b.Max.Y; y += 2 {
+    for x := b.Min.X; x < b.Max.X; x++ {
+      tr, tg, tb, _ := m.At(x, y).RGBA()
+      br, bg, bb := tr, tg, tb
+      if y + 1 < b.Max.Y {
+        br, bg, bb, _ = m.At(x, y + 1).RGBA()
+      }
+      fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀",
+        tr >> 8, tg >> 8, tb >> 8, br >> 8, bg >> 8, bb >> 8)
+    }
+    fmt.Fprint(w, "\x1b[0m\r\n")
+  }
+}
+
+// Scale m up to cols x rows by repeating pixels
+type blocky struct {
+  image.Image
+  cols, rows int
+}
+
+func (b blocky) Bounds() image.Rectangle {
+  return image.Rect(0, 0, b.cols, b.rows)
+}
+
+func (b blocky) At(x, y int) color.Color {
+  small := b.Image.Bounds()
+  return b.Image.At(x * small.Dx() / b.cols, y * small.Dy() / b.rows)
+}
+
+// Draw view to w, first coarsely and then in full, unless ctx is done first
+func drawProgressively(ctx context.Context, w *bufio.Writer, view RenderConfig, status string) {
+  coarse := view
+  coarse.Cols, coarse.Rows = max(1, view.Cols / 4), max(1, view.Rows / 4)
+  coarse.ScaleX, coarse.ScaleY = 1, 1
+  for _, cfg := range []RenderConfig{coarse, view} {
+    m, err := renderContext(ctx, cfg)
+    if err != nil {
+      return
+    }
+    fmt.Fprint(w, "\x1b[H")
+    writeANSI(w, blocky{m, view.Cols, view.Rows})
+    fmt.Fprint(w, "\x1b[2K", status)
+    w.Flush()
+  }
+}
+
+// Read key presses from r: "up", "down", "left", "right", "in", "out", and
+// "quit"; closing keys at the end of input
+func readKeys(r io.Reader, keys chan string) {
+  defer close(keys)
+  buf := make([]byte, 64)
+  for {
+    n, err := r.Read(buf)
+    if err != nil {
+      return
+    }
+    in := buf[:n]
+    for len(in) > 0 {
+      key := ""
+      switch {
+      case len(in) >= 3 && in[0] == 0x1b && in[1] == '[':
+        key = map[byte]string{'A': "up", 'B': "down", 'C': "right", 'D': "left"}[in[2]]
+        in = in[3:]
+      case in[0] == '+' || in[0] == '=':
+        key, in = "in", in[1:]
+      case in[0] == '-' || in[0] == '_':
+        key, in = "out", in[1:]
+      case in[0] == 'q' || in[0] == 3 || in[0] == 0x1b: // 3 is ctrl-C
+        key, in = "quit", in[1:]
+      default:
+        in = in[1:]
+      }
+      if key != "" {
+        keys <- key
+      }
+    }
+  }
+}
+
+// Explore interactively in the terminal, starting from cfg's view. Arrow keys
+// pan, + and - zoom, and q quits. If autoIter is set, the iteration cap
+// follows the zoom level.
+func runTUI(cfg RenderConfig, autoIter bool) error {
+  restore, err := rawTerminal(os.Stdin.Fd())
+  if err != nil {
+    return err
+  }
+  defer restore()
+  out := bufio.NewWriter(os.Stdout)
+  fmt.Fprint(out, "\x1b[?1049h\x1b[?25l") // Alternate screen, no cursor
+  defer func() {
+    fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+    out.Flush()
+  }()
+
+  keys := make(chan string)
+  go readKeys(os.Stdin, keys)
+  resized := make(chan os.Signal, 1)
+  notifyResize(resized)
+
+  region := RegionOf(cfg)
+  for {
+    cols, rows, err := terminalSize(os.Stdout.Fd())
+    if err != nil {
+      return err
+    }
+    region = region.Fit(cols, max(1, rows - 1) * 2) // Leave a line for status
+    view := cfg
+    region.Apply(&view)
+    if autoIter {
+      view.Iterations = view.autoIterations()
+    }
+    view.ROI = image.Rectangle{}
+    view.MmapFile = ""
+    status := fmt.Sprintf("%.6g%+.6gi  width %.3g  %d iterations  arrows pan, +/- zoom, q quits",
+      real(region.Center), imag(region.Center), region.Width, view.Iterations)
+
+    // Draw until the next key press or resize
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+      defer close(done)
+      drawProgressively(ctx, out, view, status)
+    }()
+    key, ok := "", true
+    select {
+    case key, ok = <- keys:
+    case <- resized:
+    }
+    cancel()
+    <- done
+
+    switch {
+    case !ok || key == "quit":
+      return nil
+    case key == "up":
+      region = region.PanBy(0, region.Height * tuiPan)
+    case key == "down":
+      region = region.PanBy(0, -region.Height * tuiPan)
+    case key == "left":
+      region = region.PanBy(-region.Width * tuiPan, 0)
+    case key == "right":
+      region = region.PanBy(region.Width * tuiPan, 0)
+    case key == "in":
+      region = region.ZoomIn(tuiZoom)
+    case key == "out":
+      region = region.ZoomOut(tuiZoom)
+    }
+  }
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "os"
+  "runtime"
+  "runtime/debug"
+)
+
+// Write what this binary was built from to w: the module version, the Go
+// toolchain and platform, and the version control revision, if the build
+// recorded them
+func writeVersion(w io.Writer) {
+  version := "unknown"
+  settings := map[string]string{}
+  if info, ok := debug.ReadBuildInfo(); ok {
+    if info.Main.Version != "" {
+      version = info.Main.Version
+    }
+    for _, s := range info.Settings {
+      settings[s.Key] = s.Value
+    }
+  }
+  fmt.Fprintf(w, "mandelbarf %s\n", version)
+  fmt.Fprintf(w, "%s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+  rev, ok := settings["vcs.revision"]
+  if !ok {
+    fmt.Fprintf(w, "no revision recorded\n")
+    return
+  }
+  fmt.Fprintf(w, "%s revision %s", settings["vcs"], rev)
+  if t := settings["vcs.time"]; t != "" {
+    fmt.Fprintf(w, ", committed %s", t)
+  }
+  if settings["vcs.modified"] == "true" {
+    fmt.Fprintf(w, ", with uncommitted changes")
+  }
+  fmt.Fprintf(w, "\n")
+}
+
+// Print the version, for bug reports
+func versionCmd(args []string) error {
+  if len(args) > 0 {
+    return fmt.Errorf("version: unexpected arguments %q", args)
+  }
+  writeVersion(os.Stdout)
+  return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+  "image"
+  "image/color"
+)
+
+const wrapTolerance = 2 // How many times the usual difference between neighboring columns the seam of -wrap-x may be
+
+// How much m's right column differs from its left, which -wrap-x puts side
+// by side, and how much neighboring columns differ on average, both as the
+// mean absolute difference of their channels, from 0 to 255
+func wrapSeam(m image.Image) (seam, typical float64) {
+  b := m.Bounds()
+  diff := func(x0, x1 int) float64 {
+    sum := 0.0
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+      p := color.RGBAModel.Convert(m.At(x0, y)).(color.RGBA)
+      q := color.RGBAModel.Convert(m.At(x1, y)).(color.RGBA)
+      sum += float64(absInt(int(p.R) - int(q.R)) + absInt(int(p.G) - int(q.G)) +
+        absInt(int(p.B) - int(q.B)) + absInt(int(p.A) - int(q.A))) / 4
+    }
+    return sum / float64(b.Dy())
+  }
+  for x := b.Min.X; x < b.Max.X - 1; x++ {
+    typical += diff(x, x + 1)
+  }
+  typical /= float64(max(1, b.Dx() - 1))
+  return diff(b.Max.X - 1, b.Min.X), typical
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+  "math"
+  "math/cmplx"
+  "testing"
+)
+
+// The mandelbrot set over circles about 0, going once around as re goes
+// from 0 to 1, and from radius 0.3 to 0.8 as im goes from -1 to 1; so
+// periodic in re, with period 1
+type circleFractal struct{}
+
+func (circleFractal) Init(p complex128) (z, c complex128) {
+  c = cmplx.Rect(0.55 + 0.25 * imag(p), 2 * math.Pi * real(p))
+  return c, c
+}
+
+func (circleFractal) Step(z, c complex128) complex128 {
+  return z*z + c
+}
+
+// Over one period, the right column matches up with the left as well as
+// neighboring columns match, however the image is antialiased and
+// filtered; over a view that isn't a period, it doesn't
+func TestWrapSeam(t *testing.T) {
+  RegisterFractal("circle", fixed(circleFractal{}), [4]float64{0, 1, -1, 1}, 256)
+  for _, args := range [][]string{
+    nil,
+    {"-aa", "smart"},
+    {"-filter", "lanczos"},
+    {"-scale", "2.5"},
+    {"-coloring", "smooth", "-linear-light"},
+  } {
+    cfg := testConfig(t, append(args, "-fractal", "circle", "-wrap-x")...)
+    m, err := Render(cfg)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if seam, typical := wrapSeam(m); seam > wrapTolerance * typical {
+      t.Errorf("%v: seam differs by %.2f, but neighboring columns only %.2f", args, seam, typical)
+    }
+  }
+
+  cfg := testConfig(t, "-wrap-x", "-bounds", "-1,0.2,-0.6,0.6")
+  m, err := Render(cfg)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if seam, typical := wrapSeam(m); seam <= wrapTolerance * typical {
+    t.Errorf("the mandelbrot set's seam differs by only %.2f, with neighboring columns %.2f", seam, typical)
+  }
+}